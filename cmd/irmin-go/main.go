@@ -0,0 +1,473 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+// Command irmin-go reads and updates an Irmin store over the REST API.
+//
+// Usage:
+//
+//	irmin-go [flags] <command> [arguments]
+//
+// Run irmin-go -h for the list of commands.
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/MagnusS/irmin-go/irmin"
+)
+
+const usage = `Usage: irmin-go [flags] <command> [arguments]
+
+Commands:
+  version                        print the Irmin version
+  commands                       list the commands supported by Irmin
+  head                           print the head commit
+  read <key>                     print a value
+  write <key> [value]            store a value, read from stdin if not given
+  rm [-r] <key>                  remove a key, or a subtree with -r
+  ls [path]                      list the keys in a path
+  tree [path]                    print all keys and values below a path
+  watch [path]                   print changes below a path until interrupted
+  clone [-force] <branch>        create a branch from the current branch
+  cas [-missing] <key> <old> [new]
+                                 set a key if it has the value old, or if it
+                                 doesn't exist with -missing (then old is
+                                 omitted). new is read from stdin if not given
+  view begin [path]              create a view and print its id
+  view read <id> <key>           print a value in a view
+  view write <id> <key> [value]  store a value in a view and print the new id
+  view rm <id> <key>             remove a key from a view and print the new id
+  view ls <id> [path]            list the keys in a path in a view
+  view commit <id> [path]        merge a view, path must match view begin
+
+Flags:
+`
+
+// cli contains the state of one invocation
+type cli struct {
+	ctx     context.Context
+	conn    *irmin.Conn
+	message string
+	json    bool
+	stdin   io.Reader
+	stdout  io.Writer
+}
+
+type command func(c *cli, args []string) error
+
+var commands = map[string]command{
+	"version":  (*cli).version,
+	"commands": (*cli).commands,
+	"head":     (*cli).head,
+	"read":     (*cli).read,
+	"write":    (*cli).write,
+	"rm":       (*cli).rm,
+	"ls":       (*cli).ls,
+	"tree":     (*cli).tree,
+	"watch":    (*cli).watch,
+	"clone":    (*cli).clone,
+	"cas":      (*cli).cas,
+	"view":     (*cli).view,
+}
+
+// errUsage is returned when a command is called with invalid arguments
+var errUsage = errors.New("invalid arguments")
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command in args and returns the exit status
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("irmin-go", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	uri := fs.String("uri", "http://127.0.0.1:8080", "Irmin REST API `URI`")
+	branch := fs.String("branch", "", "`branch` or commit hash to use, master if not set")
+	owner := fs.String("owner", "irmin-go", "task owner (commit author)")
+	message := fs.String("m", "", "commit `message`")
+	jsonOut := fs.Bool("json", false, "print results as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "irmin-go: unknown command %s\n", fs.Arg(0))
+		fs.Usage()
+		return 2
+	}
+
+	u, err := url.Parse(*uri)
+	if err != nil {
+		fmt.Fprintf(stderr, "irmin-go: invalid URI: %s\n", err)
+		return 2
+	}
+	c := &cli{
+		ctx:     ctx,
+		conn:    irmin.Create(u, *owner).FromTree(*branch),
+		message: *message,
+		json:    *jsonOut,
+		stdin:   stdin,
+		stdout:  stdout,
+	}
+	if err := cmd(c, fs.Args()[1:]); err != nil {
+		if err == errUsage {
+			fmt.Fprintf(stderr, "irmin-go: invalid arguments for %s\n", fs.Arg(0))
+			fs.Usage()
+			return 2
+		}
+		fmt.Fprintf(stderr, "irmin-go: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// task returns a task with the -m message, or a default message
+func (c *cli) task(format string, args ...interface{}) irmin.Task {
+	if c.message != "" {
+		return c.conn.NewTask(c.message)
+	}
+	return c.conn.NewTask(fmt.Sprintf(format, args...))
+}
+
+// output prints text, or v as JSON if -json is set
+func (c *cli) output(text string, v interface{}) error {
+	if c.json {
+		return json.NewEncoder(c.stdout).Encode(v)
+	}
+	if text != "" {
+		_, err := fmt.Fprintln(c.stdout, text)
+		return err
+	}
+	return nil
+}
+
+// value returns args[i], or stdin if there are not enough arguments
+func (c *cli) value(args []string, i int) ([]byte, error) {
+	if len(args) > i {
+		return []byte(args[i]), nil
+	}
+	return ioutil.ReadAll(c.stdin)
+}
+
+// optPath returns args[0] as a path, or the root if not given
+func optPath(args []string) irmin.Path {
+	if len(args) == 0 {
+		return irmin.Path{}
+	}
+	return irmin.ParsePath(args[0])
+}
+
+func jsonValue(v []byte) *irmin.Value {
+	i := irmin.Value(v)
+	return &i
+}
+
+func (c *cli) version(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	v, err := c.conn.VersionContext(c.ctx)
+	if err != nil {
+		return err
+	}
+	return c.output(v, map[string]string{"version": v})
+}
+
+func (c *cli) commands(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	cmds, err := c.conn.AvailableCommandsContext(c.ctx)
+	if err != nil {
+		return err
+	}
+	return c.output(strings.Join(cmds, "\n"), cmds)
+}
+
+func (c *cli) head(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	h, err := c.conn.HeadContext(c.ctx)
+	if err != nil {
+		return err
+	}
+	if h == nil {
+		return c.output("", map[string]interface{}{"head": nil})
+	}
+	return c.output(hex.EncodeToString(h), map[string]string{"head": hex.EncodeToString(h)})
+}
+
+func (c *cli) read(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	key := irmin.ParsePath(args[0])
+	v, err := c.conn.ReadContext(c.ctx, key)
+	if err != nil {
+		return err
+	}
+	return c.printValue(key, v)
+}
+
+// printValue prints a value unchanged, or as JSON if -json is set
+func (c *cli) printValue(key irmin.Path, v []byte) error {
+	if c.json {
+		return c.output("", map[string]interface{}{"key": key.String(), "value": jsonValue(v)})
+	}
+	_, err := c.stdout.Write(v)
+	return err
+}
+
+func (c *cli) write(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errUsage
+	}
+	v, err := c.value(args, 1)
+	if err != nil {
+		return err
+	}
+	h, err := c.conn.UpdateContext(c.ctx, c.task("Update %s", args[0]), irmin.ParsePath(args[0]), v)
+	if err != nil {
+		return err
+	}
+	return c.output(h, map[string]string{"commit": h})
+}
+
+func (c *cli) rm(args []string) error {
+	fs := flag.NewFlagSet("rm", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	recursive := fs.Bool("r", false, "")
+	if fs.Parse(args) != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+	key := irmin.ParsePath(fs.Arg(0))
+	if *recursive {
+		return c.conn.RemoveRecContext(c.ctx, c.task("Remove %s recursively", fs.Arg(0)), key)
+	}
+	return c.conn.RemoveContext(c.ctx, c.task("Remove %s", fs.Arg(0)), key)
+}
+
+func (c *cli) ls(args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	paths, err := c.conn.ListContext(c.ctx, optPath(args))
+	if err != nil {
+		return err
+	}
+	return c.printPaths(paths)
+}
+
+func (c *cli) printPaths(paths []irmin.Path) error {
+	names := make([]string, len(paths))
+	for i := range paths {
+		names[i] = paths[i].String()
+	}
+	return c.output(strings.Join(names, "\n"), names)
+}
+
+func (c *cli) tree(args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	m, err := c.conn.ReadTreeContext(c.ctx, optPath(args))
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(m))
+	res := make(map[string]*irmin.Value, len(m))
+	for k, v := range m {
+		keys = append(keys, k)
+		res[k] = jsonValue(v)
+	}
+	sort.Strings(keys)
+	lines := make([]string, len(keys))
+	for i, k := range keys {
+		lines[i] = fmt.Sprintf("%s=%s", k, m[k])
+	}
+	return c.output(strings.Join(lines, "\n"), res)
+}
+
+func (c *cli) watch(args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	w := c.conn.WatchPathResilient(c.ctx, optPath(args), nil, irmin.DefaultBackoff)
+	for wc := range w.C {
+		type change struct {
+			Change string `json:"change"`
+			Key    string `json:"key"`
+		}
+		commit := hex.EncodeToString(wc.Commit)
+		changes := make([]change, len(wc.Changes))
+		lines := make([]string, len(wc.Changes))
+		for i, ch := range wc.Changes {
+			changes[i] = change{ch.Change, ch.Key.String()}
+			lines[i] = fmt.Sprintf("%s %s %s", commit, ch.Change, ch.Key.String())
+		}
+		if err := c.output(strings.Join(lines, "\n"), map[string]interface{}{"commit": commit, "changes": changes}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *cli) clone(args []string) error {
+	fs := flag.NewFlagSet("clone", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	force := fs.Bool("force", false, "")
+	if fs.Parse(args) != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+	return c.conn.CloneContext(c.ctx, c.task("Clone %s", fs.Arg(0)), fs.Arg(0), *force)
+}
+
+func (c *cli) cas(args []string) error {
+	fs := flag.NewFlagSet("cas", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	missing := fs.Bool("missing", false, "")
+	if fs.Parse(args) != nil {
+		return errUsage
+	}
+	args = fs.Args()
+
+	var old *[]byte
+	n := 1 // index of new value
+	if !*missing {
+		if len(args) < 2 {
+			return errUsage
+		}
+		o := []byte(args[1])
+		old, n = &o, 2
+	}
+	if len(args) < n || len(args) > n+1 {
+		return errUsage
+	}
+	v, err := c.value(args, n)
+	if err != nil {
+		return err
+	}
+	h, err := c.conn.CompareAndSetContext(c.ctx, c.task("Compare and set %s", args[0]), irmin.ParsePath(args[0]), old, &v)
+	if err != nil {
+		return err
+	}
+	return c.output(h, map[string]string{"commit": h})
+}
+
+func (c *cli) view(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	sub, args := args[0], args[1:]
+	if sub == "begin" {
+		if len(args) > 1 {
+			return errUsage
+		}
+		v, err := c.conn.CreateViewContext(c.ctx, c.task("Create view"), optPath(args))
+		if err != nil {
+			return err
+		}
+		return c.output(v.ID(), map[string]string{"view": v.ID()})
+	}
+
+	if len(args) == 0 {
+		return errUsage
+	}
+	id, args := args[0], args[1:]
+	var path irmin.Path
+	if sub == "commit" {
+		path = optPath(args)
+	}
+	v, err := c.conn.OpenView(id, path)
+	if err != nil {
+		return err
+	}
+
+	switch sub {
+	case "read":
+		if len(args) != 1 {
+			return errUsage
+		}
+		key := irmin.ParsePath(args[0])
+		val, err := v.ReadContext(c.ctx, key)
+		if err != nil {
+			return err
+		}
+		return c.printValue(key, val)
+	case "write":
+		if len(args) < 1 || len(args) > 2 {
+			return errUsage
+		}
+		val, err := c.value(args, 1)
+		if err != nil {
+			return err
+		}
+		if _, err := v.UpdateContext(c.ctx, c.task("Update %s", args[0]), irmin.ParsePath(args[0]), val); err != nil {
+			return err
+		}
+		return c.output(v.ID(), map[string]string{"view": v.ID()})
+	case "rm":
+		if len(args) != 1 {
+			return errUsage
+		}
+		if err := v.RemoveContext(c.ctx, c.task("Remove %s", args[0]), irmin.ParsePath(args[0])); err != nil {
+			return err
+		}
+		return c.output(v.ID(), map[string]string{"view": v.ID()})
+	case "ls":
+		if len(args) > 1 {
+			return errUsage
+		}
+		paths, err := v.ListContext(c.ctx, optPath(args))
+		if err != nil {
+			return err
+		}
+		return c.printPaths(paths)
+	case "commit":
+		if len(args) > 1 {
+			return errUsage
+		}
+		tree := c.conn.Tree()
+		if tree == "" {
+			tree = "master"
+		}
+		return v.MergePathContext(c.ctx, c.task("Merge view"), tree, path)
+	}
+	return errUsage
+}
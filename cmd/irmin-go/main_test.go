@@ -0,0 +1,99 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/MagnusS/irmin-go/irmin/irmintest"
+)
+
+// irmingo runs the command against srv and returns stdout. The test fails if the exit status is not status.
+func irmingo(t *testing.T, srv *irmintest.Server, stdin string, status int, args ...string) string {
+	var stdout, stderr bytes.Buffer
+	args = append([]string{"-uri", srv.URL}, args...)
+	if s := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr); s != status {
+		t.Fatalf("%v: expected exit status %d, got %d: %s", args, status, s, stderr.String())
+	}
+	return stdout.String()
+}
+
+func TestCommands(t *testing.T) {
+	srv := irmintest.NewServer()
+	defer srv.Close()
+
+	irmingo(t, srv, "", 0, "write", "/a/b", "foo")
+	irmingo(t, srv, "from stdin", 0, "-m", "piped", "write", "/a/c")
+	if out := irmingo(t, srv, "", 0, "read", "/a/c"); out != "from stdin" {
+		t.Fatalf("expected value from stdin, got %q", out)
+	}
+	if out := irmingo(t, srv, "", 0, "ls", "/a"); out != "/a/b\n/a/c\n" {
+		t.Fatalf("unexpected ls output %q", out)
+	}
+	if out := irmingo(t, srv, "", 0, "tree"); out != "/a/b=foo\n/a/c=from stdin\n" {
+		t.Fatalf("unexpected tree output %q", out)
+	}
+
+	var res struct {
+		Key   string
+		Value string
+	}
+	if err := json.Unmarshal([]byte(irmingo(t, srv, "", 0, "-json", "read", "/a/b")), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Key != "/a/b" || res.Value != "foo" {
+		t.Fatalf("unexpected JSON output %+v", res)
+	}
+
+	irmingo(t, srv, "", 0, "cas", "/a/b", "foo", "bar")
+	irmingo(t, srv, "", 1, "cas", "/a/b", "foo", "baz") // value is now bar
+	irmingo(t, srv, "", 0, "cas", "-missing", "/a/d", "new")
+	irmingo(t, srv, "", 0, "clone", "dev")
+	irmingo(t, srv, "", 0, "rm", "-r", "/a")
+	irmingo(t, srv, "", 1, "read", "/a/b")
+	if out := irmingo(t, srv, "", 0, "-branch", "dev", "read", "/a/d"); out != "new" {
+		t.Fatalf("expected value in cloned branch, got %q", out)
+	}
+
+	irmingo(t, srv, "", 2, "read")
+	irmingo(t, srv, "", 2, "nonexistent")
+}
+
+func TestViewCommands(t *testing.T) {
+	srv := irmintest.NewServer()
+	defer srv.Close()
+
+	irmingo(t, srv, "", 0, "write", "/a/b", "foo")
+	id := strings.TrimSpace(irmingo(t, srv, "", 0, "view", "begin", "/a"))
+	id = strings.TrimSpace(irmingo(t, srv, "", 0, "view", "write", id, "c", "bar"))
+	id = strings.TrimSpace(irmingo(t, srv, "", 0, "view", "rm", id, "b"))
+	if out := irmingo(t, srv, "", 0, "view", "read", id, "c"); out != "bar" {
+		t.Fatalf("expected bar in view, got %q", out)
+	}
+	if out := irmingo(t, srv, "", 0, "read", "/a/b"); out != "foo" {
+		t.Fatalf("store should not change before commit, got %q", out)
+	}
+
+	irmingo(t, srv, "", 0, "view", "commit", id, "/a")
+	if out := irmingo(t, srv, "", 0, "ls", "/a"); out != "/a/c\n" {
+		t.Fatalf("unexpected keys after commit %q", out)
+	}
+}
@@ -61,13 +61,16 @@ func main() {
 		}
 	}
 	{ // iter
-		var ch <-chan *irmin.Path
+		var ch <-chan *irmin.IterResult
 		if ch, err = r.Iter(); err != nil {
 			panic(err)
 		}
 
 		for p := range ch {
-			fmt.Printf("iter: %s\n", (*p).String())
+			if p.Error != nil {
+				panic(p.Error)
+			}
+			fmt.Printf("iter: %s\n", p.Path.String())
 		}
 	}
 	{ // iter on head
@@ -76,27 +79,33 @@ func main() {
 			panic(err)
 		}
 		t := r.FromTree(hex.EncodeToString(head))
-		var ch <-chan *irmin.Path
+		var ch <-chan *irmin.IterResult
 		if ch, err = t.Iter(); err != nil {
 			panic(err)
 		}
 
 		for p := range ch {
-			fmt.Printf("iter from HEAD: %s\n", (*p).String())
+			if p.Error != nil {
+				panic(p.Error)
+			}
+			fmt.Printf("iter from HEAD: %s\n", p.Path.String())
 		}
 	}
 	{ // iter + read
-		var ch <-chan *irmin.Path
+		var ch <-chan *irmin.IterResult
 		if ch, err = r.Iter(); err != nil {
 			panic(err)
 		}
 
 		for p := range ch {
-			d, err := r.ReadString(*p)
+			if p.Error != nil {
+				panic(p.Error)
+			}
+			d, err := r.ReadString(p.Path)
 			if err != nil {
 				panic(err)
 			}
-			fmt.Printf("%s=%s\n", (*p).String(), d)
+			fmt.Printf("%s=%s\n", p.Path.String(), d)
 		}
 	}
 	{ // update + read
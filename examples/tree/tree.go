@@ -31,11 +31,14 @@ func main() {
 		panic(err)
 	}
 
-	for path := range ch {
-		d, err := r.ReadString(*path) // Read key
+	for p := range ch {
+		if p.Error != nil {
+			panic(p.Error)
+		}
+		d, err := r.ReadString(p.Path) // Read key
 		if err != nil {
 			panic(err)
 		}
-		fmt.Printf("%s=%s\n", (*path).String(), d)
+		fmt.Printf("%s=%s\n", p.Path.String(), d)
 	}
 }
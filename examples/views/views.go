@@ -31,12 +31,15 @@ func listDb(r *irmin.Conn) {
 		panic(err)
 	}
 
-	for path := range ch {
-		d, err := r.ReadString(*path) // Read key
+	for p := range ch {
+		if p.Error != nil {
+			panic(p.Error)
+		}
+		d, err := r.ReadString(p.Path) // Read key
 		if err != nil {
 			panic(err)
 		}
-		fmt.Printf("%s=%s\n", (*path).String(), d)
+		fmt.Printf("%s=%s\n", p.Path.String(), d)
 	}
 }
 
@@ -94,7 +97,10 @@ func main() {
 		panic(err)
 	}
 	for p := range ch {
-		fmt.Printf("View path: %s\n", p.String())
+		if p.Error != nil {
+			panic(p.Error)
+		}
+		fmt.Printf("View path: %s\n", p.Path.String())
 	}
 
 	// Merge view 2
@@ -49,6 +49,9 @@ func main() {
 		panic(err)
 	}
 	for a := range ch {
+		if a.Error != nil {
+			panic(a.Error)
+		}
 		fmt.Printf("commit: %s\n", hex.EncodeToString(a.Commit))
 		for _, c := range a.Changes {
 			fmt.Printf("  %s %s\n", c.Change, c.Key.String())
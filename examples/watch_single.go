@@ -44,11 +44,14 @@ func main() {
 	key := irmin.ParsePath("/view-test/exists")
 	fmt.Printf("Watching %s\n", key.String())
 	fmt.Printf("(run examples/views/views.go example to test)\n")
-	ch, err := r.Watch(key)
+	ch, err := r.Watch(key, nil)
 	if err != nil {
 		panic(err)
 	}
 	for c := range ch {
+		if c.Error != nil {
+			panic(c.Error)
+		}
 		fmt.Printf("commit: %s value: %s\n", hex.EncodeToString(c.Commit), c.Value)
 	}
 }
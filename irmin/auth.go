@@ -0,0 +1,149 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TokenSource returns the bearer token sent with every request, see WithTokenSource. Token is called before every
+// request, including retries and reconnects of watches, so a new token is used as soon as it is available.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticToken is a TokenSource that always returns the same token
+type StaticToken string
+
+// Token returns the token
+func (t StaticToken) Token(ctx context.Context) (string, error) {
+	return string(t), nil
+}
+
+// TokenFunc is a function that implements TokenSource
+type TokenFunc func(ctx context.Context) (string, error)
+
+// Token calls f
+func (f TokenFunc) Token(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// RefreshingTokenSource is a TokenSource that caches a token until shortly before it expires. The token is also
+// refreshed when Irmin, or a proxy in front of it, replies with 401 Unauthorized.
+type RefreshingTokenSource struct {
+	fetch func(ctx context.Context) (token string, expiry time.Time, err error)
+	early time.Duration
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// NewRefreshingTokenSource returns a TokenSource that gets tokens from fetch. A new token is fetched when the cached
+// token expires in less than early. A zero expiry means the token does not expire.
+func NewRefreshingTokenSource(fetch func(ctx context.Context) (string, time.Time, error), early time.Duration) *RefreshingTokenSource {
+	return &RefreshingTokenSource{fetch: fetch, early: early}
+}
+
+// Token returns the cached token, or fetches a new token if it has expired
+func (s *RefreshingTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && (s.expiry.IsZero() || time.Now().Add(s.early).Before(s.expiry)) {
+		return s.token, nil
+	}
+	token, expiry, err := s.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.token, s.expiry = token, expiry
+	return token, nil
+}
+
+// Invalidate removes the cached token, so the next call to Token fetches a new token
+func (s *RefreshingTokenSource) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = ""
+}
+
+// authenticate adds credentials to req, unless the Authorization header is already set. A bearer token from the
+// TokenSource is used if set, otherwise basic auth.
+func (c *Client) authenticate(req *http.Request) error {
+	if req.Header.Get("Authorization") != "" {
+		return nil
+	}
+	if c.tokenSource != nil {
+		token, err := c.tokenSource.Token(req.Context())
+		if err != nil {
+			return fmt.Errorf("unable to get token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+	if c.basicAuth != nil {
+		password, _ := c.basicAuth.Password()
+		req.SetBasicAuth(c.basicAuth.Username(), password)
+	}
+	return nil
+}
+
+// unauthorized is called when a request is rejected with 401 Unauthorized. The cached token is removed if the
+// TokenSource supports it.
+func (c *Client) unauthorized() {
+	if s, ok := c.tokenSource.(interface{ Invalidate() }); ok {
+		s.Invalidate()
+	}
+}
+
+// applyTLS sets the TLS configuration in the transport of the http.Client. The configuration from WithTLSConfig
+// replaces the one in the transport, otherwise the transport's configuration is kept. Client certificates are added
+// to it. The http.Client, transport and configuration are copied, so clients shared with other code are not changed.
+func (c *Client) applyTLS() error {
+	var t *http.Transport
+	switch rt := c.httpClient.Transport.(type) {
+	case nil:
+		dt, ok := http.DefaultTransport.(*http.Transport)
+		if !ok {
+			return errors.New("TLS options require http.DefaultTransport to be an *http.Transport")
+		}
+		t = dt.Clone()
+	case *http.Transport:
+		t = rt.Clone()
+	default:
+		return errors.New("TLS options require the http.Client to use an *http.Transport")
+	}
+	// Clone copies the transport's TLSClientConfig
+	if c.tlsConfig != nil {
+		t.TLSClientConfig = c.tlsConfig.Clone()
+	} else if t.TLSClientConfig == nil {
+		t.TLSClientConfig = new(tls.Config)
+	}
+	// The slice may be shared with the original configuration
+	certs := append([]tls.Certificate{}, t.TLSClientConfig.Certificates...)
+	t.TLSClientConfig.Certificates = append(certs, c.clientCerts...)
+	hc := *c.httpClient
+	hc.Transport = t
+	c.httpClient = &hc
+	return nil
+}
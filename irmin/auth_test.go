@@ -0,0 +1,206 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBasicAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "irmin" || password != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"result":[],"version":"0.10.0"}`)
+	}))
+	defer srv.Close()
+
+	uri, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri.User = url.UserPassword("irmin", "secret")
+	conn := Create(uri, "irmin-go-tester")
+	if _, err := conn.List(ParsePath("/a")); err != nil {
+		t.Fatal(err)
+	}
+	if u, err := conn.MakeCallURL("list", nil, false); err != nil || u.User != nil {
+		t.Fatalf("credentials should be removed from the URL, got %v", u)
+	}
+	if uri.User == nil {
+		t.Fatal("the URL passed to Create should not be changed")
+	}
+
+	var status *HTTPStatusError
+	conn = Create(uri, "irmin-go-tester", WithBasicAuth("irmin", "wrong"))
+	if _, err := conn.List(ParsePath("/a")); !errors.As(err, &status) || status.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with WithBasicAuth credentials, got %v", err)
+	}
+}
+
+func TestTokenSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token-2" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/iter":
+			fmt.Fprint(w, `[{"stream":"start"},{"version":"0.10.0"},{"result":["a"]},{"stream":"end"}]`)
+		default:
+			fmt.Fprint(w, `{"result":[],"version":"0.10.0"}`)
+		}
+	}))
+	defer srv.Close()
+
+	uri, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	fetched := 0
+	ts := NewRefreshingTokenSource(func(ctx context.Context) (string, time.Time, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetched++
+		return fmt.Sprintf("token-%d", fetched), time.Now().Add(time.Hour), nil
+	}, time.Minute)
+	conn := Create(uri, "irmin-go-tester", WithTokenSource(ts), WithBasicAuth("ignored", "ignored"))
+
+	var status *HTTPStatusError
+	if _, err := conn.List(ParsePath("/a")); !errors.As(err, &status) || status.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected first token to be rejected, got %v", err)
+	}
+	if _, err := conn.List(ParsePath("/a")); err != nil { // a new token is fetched after 401
+		t.Fatal(err)
+	}
+	ch, err := conn.Iter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := <-ch; r.Error != nil || r.Path.String() != "/a" {
+		t.Fatalf("expected /a, got %v", r)
+	}
+	mu.Lock()
+	if fetched != 2 {
+		t.Errorf("expected 2 tokens to be fetched, got %d", fetched)
+	}
+	mu.Unlock()
+
+	errToken := errors.New("token service unavailable")
+	conn = Create(uri, "irmin-go-tester", WithTokenSource(TokenFunc(func(ctx context.Context) (string, error) {
+		return "", errToken
+	})))
+	if _, err := conn.List(ParsePath("/a")); !errors.Is(err, errToken) {
+		t.Fatalf("expected token error, got %v", err)
+	}
+}
+
+// testCertificate returns a self-signed client certificate
+func testCertificate(t *testing.T) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "irmin-go-tester"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, cert
+}
+
+func TestClientCertificate(t *testing.T) {
+	cert, leaf := testCertificate(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(leaf)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].Subject.CommonName != "irmin-go-tester" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"result":[],"version":"0.10.0"}`)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.StartTLS()
+	defer srv.Close()
+
+	uri, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	conn := Create(uri, "irmin-go-tester", WithClientCertificate(cert), WithTLSConfig(&tls.Config{RootCAs: roots}))
+	if _, err := conn.List(ParsePath("/a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Create(uri, "irmin-go-tester", WithTLSConfig(&tls.Config{RootCAs: roots})).List(ParsePath("/a")); err == nil {
+		t.Fatal("expected TLS error without client certificate")
+	}
+
+	// The certificate is added to the TLS configuration of the transport, which is kept
+	base := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "example.com"}}
+	conn = Create(uri, "irmin-go-tester", WithHTTPClient(&http.Client{Transport: base}), WithClientCertificate(cert))
+	if _, err := conn.List(ParsePath("/a")); err != nil {
+		t.Fatal(err)
+	}
+	cfg := conn.httpClient.Transport.(*http.Transport).TLSClientConfig
+	if cfg.RootCAs != roots || cfg.ServerName != "example.com" || len(cfg.Certificates) != 1 {
+		t.Fatalf("TLS configuration of the transport not kept: %+v", cfg)
+	}
+	if len(base.TLSClientConfig.Certificates) != 0 {
+		t.Fatal("TLS configuration of the http.Client's transport was modified")
+	}
+
+	rt := new(recordingTransport)
+	conn = Create(uri, "irmin-go-tester", WithHTTPClient(&http.Client{Transport: rt}), WithClientCertificate(cert))
+	if _, err := conn.List(ParsePath("/a")); err == nil || len(rt.requests) != 0 {
+		t.Fatal("expected error when TLS options can't be applied to the transport")
+	}
+}
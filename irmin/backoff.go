@@ -0,0 +1,62 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff describes exponential backoff with jitter. The zero value is replaced by DefaultBackoff. Otherwise a zero
+// Max means the delay is not limited, a Multiplier below 1 keeps the delay constant and a zero Jitter gives fixed
+// delays.
+type Backoff struct {
+	Initial    time.Duration // Delay before the first retry
+	Max        time.Duration // Maximum delay
+	Multiplier float64       // Factor the delay is multiplied with after each attempt
+	Jitter     float64       // Fraction of the delay that is randomized, between 0 and 1
+}
+
+// DefaultBackoff starts at 100ms and doubles the delay up to 30s, randomizing 20% of each delay
+var DefaultBackoff = Backoff{
+	Initial:    100 * time.Millisecond,
+	Max:        30 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// Delay returns the delay before retry number attempt, starting at 0
+func (b Backoff) Delay(attempt int) time.Duration {
+	if b == (Backoff{}) {
+		b = DefaultBackoff
+	}
+	if b.Multiplier < 1 {
+		b.Multiplier = 1
+	}
+	b.Jitter = math.Max(0, math.Min(b.Jitter, 1))
+
+	d := float64(b.Initial) * math.Pow(b.Multiplier, float64(attempt))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	d -= d * b.Jitter * rand.Float64() // randomize to avoid clients retrying at the same time
+	if d >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(d)
+}
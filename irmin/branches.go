@@ -0,0 +1,180 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+type branchesReply stringArrayReply
+type removeBranchReply stringReply
+type updateHeadReply stringReply
+type fastForwardHeadReply boolReply
+type casHeadReply boolReply
+
+// hashPath returns a commit hash as a path with one element, for commands that take a hash in the URL
+func hashPath(commit []byte) Path {
+	return Path{NewValue(hex.EncodeToString(commit))}
+}
+
+// Branches returns the names of all branches. The current tree position is ignored.
+func (rest *Conn) Branches() ([]string, error) {
+	return rest.BranchesContext(context.Background())
+}
+
+// BranchesContext is like Branches, but the request is aborted if ctx is cancelled.
+func (rest *Conn) BranchesContext(ctx context.Context) ([]string, error) {
+	var data branchesReply
+	uri, err := rest.MakeCallURL("branches", nil, false)
+	if err != nil {
+		return nil, err
+	}
+	if err = rest.CallContext(ctx, uri, nil, &data); err != nil {
+		return nil, err
+	}
+	if err = data.err(); err != nil {
+		return nil, err
+	}
+	names := make([]string, len(data.Result))
+	for i, v := range data.Result {
+		names[i] = v.String()
+	}
+	return names, nil
+}
+
+// CreateBranch creates a new branch pointing to commit. A *ConflictError is returned if the branch already exists.
+// Use Clone to create a branch from the current tree position.
+func (rest *Conn) CreateBranch(t Task, name string, commit []byte) error {
+	return rest.CreateBranchContext(context.Background(), t, name, commit)
+}
+
+// CreateBranchContext is like CreateBranch, but the request is aborted if ctx is cancelled.
+func (rest *Conn) CreateBranchContext(ctx context.Context, t Task, name string, commit []byte) error {
+	return rest.FromTree(name).CompareAndSetHeadContext(ctx, t, nil, commit)
+}
+
+// DeleteBranch removes a branch. The commits are not removed. The current tree position is ignored.
+func (rest *Conn) DeleteBranch(t Task, name string) error {
+	return rest.DeleteBranchContext(context.Background(), t, name)
+}
+
+// DeleteBranchContext is like DeleteBranch, but the request is aborted if ctx is cancelled.
+func (rest *Conn) DeleteBranchContext(ctx context.Context, t Task, name string) error {
+	var data removeBranchReply
+	uri, err := rest.MakeCallURL("remove-branch", Path{NewValue(name)}, false)
+	if err != nil {
+		return err
+	}
+	body := postRequest{t, nil}
+	if err = rest.CallContext(ctx, uri, &body, &data); err != nil {
+		return err
+	}
+	return data.err()
+}
+
+// SetHead moves the head of the current tree to commit (the update-head command). The branch is created if it
+// doesn't exist.
+func (rest *Conn) SetHead(t Task, commit []byte) error {
+	return rest.SetHeadContext(context.Background(), t, commit)
+}
+
+// SetHeadContext is like SetHead, but the request is aborted if ctx is cancelled.
+func (rest *Conn) SetHeadContext(ctx context.Context, t Task, commit []byte) error {
+	var data updateHeadReply
+	uri, err := rest.MakeCallURL("update-head", hashPath(commit), true)
+	if err != nil {
+		return err
+	}
+	body := postRequest{t, nil}
+	if err = rest.CallContext(ctx, uri, &body, &data); err != nil {
+		return err
+	}
+	return data.err()
+}
+
+// FastForward moves the head of the current tree to commit if the current head is an ancestor of commit. Returns false
+// if the head was not moved because the histories have diverged.
+func (rest *Conn) FastForward(t Task, commit []byte) (bool, error) {
+	return rest.FastForwardContext(context.Background(), t, commit)
+}
+
+// FastForwardContext is like FastForward, but the request is aborted if ctx is cancelled.
+func (rest *Conn) FastForwardContext(ctx context.Context, t Task, commit []byte) (bool, error) {
+	var data fastForwardHeadReply
+	uri, err := rest.MakeCallURL("fast-forward-head", hashPath(commit), true)
+	if err != nil {
+		return false, err
+	}
+	body := postRequest{t, nil}
+	if err = rest.CallContext(ctx, uri, &body, &data); err != nil {
+		return false, err
+	}
+	if err = data.err(); err != nil {
+		return false, err
+	}
+	return data.Result, nil
+}
+
+// CompareAndSetHead moves the head of the current tree to commit if the head is currently oldcommit. A nil oldcommit
+// means that the branch must not exist and a nil commit removes the branch. If the head differs a *ConflictError is
+// returned.
+func (rest *Conn) CompareAndSetHead(t Task, oldcommit []byte, commit []byte) error {
+	return rest.CompareAndSetHeadContext(context.Background(), t, oldcommit, commit)
+}
+
+// CompareAndSetHeadContext is like CompareAndSetHead, but the request is aborted if ctx is cancelled.
+func (rest *Conn) CompareAndSetHeadContext(ctx context.Context, t Task, oldcommit []byte, commit []byte) error {
+	var data casHeadReply
+	uri, err := rest.MakeCallURL("compare-and-set-head", nil, true)
+	if err != nil {
+		return err
+	}
+
+	opt := func(c []byte) []Value { // optional hashes are lists with 0 or 1 element
+		if c == nil {
+			return []Value{}
+		}
+		return []Value{NewValue(hex.EncodeToString(c))}
+	}
+	var body postRequest
+	if body.Data, err = json.Marshal([][]Value{opt(oldcommit), opt(commit)}); err != nil {
+		return err
+	}
+	body.Task = t
+
+	if err = rest.CallContext(ctx, uri, &body, &data); err != nil {
+		return err
+	}
+	if err = data.err(); err != nil {
+		return err
+	}
+	if !data.Result {
+		return &ConflictError{fmt.Sprintf("head of %s changed", rest.treeName())}
+	}
+	return nil
+}
+
+// treeName returns the current tree position, or master if not set
+func (rest *Conn) treeName() string {
+	if rest.tree == "" {
+		return "master"
+	}
+	return rest.tree
+}
@@ -0,0 +1,146 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/MagnusS/irmin-go/irmin"
+)
+
+func TestBranches(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	r := getConn(t, srv)
+	if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/branches/a"), []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	base, err := r.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.CreateBranch(r.NewTask("create branch"), "feature", base); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.CreateBranch(r.NewTask("create branch"), "feature", base); !errors.Is(err, irmin.ErrConflict) {
+		t.Fatalf("expected ErrConflict when creating existing branch, got %v", err)
+	}
+	names, err := r.Branches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"feature", "master"}) {
+		t.Fatalf("unexpected branches %v", names)
+	}
+
+	// Advance feature and fast-forward master to it
+	feature := r.FromTree("feature")
+	if _, err := feature.Update(r.NewTask("update key"), irmin.ParsePath("/branches/a"), []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	featureHead, err := feature.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := r.FastForward(r.NewTask("fast-forward"), featureHead); err != nil || !ok {
+		t.Fatalf("expected fast-forward to succeed, got %v %v", ok, err)
+	}
+	if v, err := r.ReadString(irmin.ParsePath("/branches/a")); err != nil || v != "bar" {
+		t.Fatalf("expected bar after fast-forward, got %s %v", v, err)
+	}
+
+	// Diverge master, fast-forward back to base must fail
+	if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/branches/b"), []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := r.FastForward(r.NewTask("fast-forward"), base); err != nil || ok {
+		t.Fatalf("expected fast-forward to fail, got %v %v", ok, err)
+	}
+
+	// Reset master to base
+	head, err := r.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.CompareAndSetHead(r.NewTask("reset"), base, featureHead); !errors.Is(err, irmin.ErrConflict) {
+		t.Fatalf("expected ErrConflict for wrong old head, got %v", err)
+	}
+	if err := r.CompareAndSetHead(r.NewTask("reset"), head, base); err != nil {
+		t.Fatal(err)
+	}
+	if err := feature.SetHead(r.NewTask("set head"), head); err != nil {
+		t.Fatal(err)
+	}
+	if h, err := feature.Head(); err != nil || !bytes.Equal(h, head) {
+		t.Fatalf("expected feature head to be moved, got %x %v", h, err)
+	}
+
+	if err := r.DeleteBranch(r.NewTask("delete branch"), "feature"); err != nil {
+		t.Fatal(err)
+	}
+	if names, err := r.Branches(); err != nil || !reflect.DeepEqual(names, []string{"master"}) {
+		t.Fatalf("unexpected branches after delete %v %v", names, err)
+	}
+}
+
+func TestBranchWritesArePosts(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	var mu sync.Mutex
+	methods := make(map[string]string)
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		methods[req.URL.Path] = req.Method
+		mu.Unlock()
+		return http.DefaultTransport.RoundTrip(req)
+	})
+	r := srv.Conn("irmin-go-tester", irmin.WithHTTPClient(&http.Client{Transport: transport}))
+	if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/branches/a"), []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	head, err := r.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.FromTree("feature").SetHead(r.NewTask("set head"), head); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.FastForward(r.NewTask("fast-forward"), head); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteBranch(r.NewTask("delete branch"), "feature"); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	h := hex.EncodeToString(head)
+	for _, p := range []string{"/tree/feature/update-head/" + h, "/fast-forward-head/" + h, "/remove-branch/feature"} {
+		if methods[p] != "POST" {
+			t.Errorf("expected POST to %s, got %q", p, methods[p])
+		}
+	}
+}
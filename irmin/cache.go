@@ -0,0 +1,276 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"unicode/utf8"
+)
+
+// CacheStats contains the cache counters of a CachedConn
+type CacheStats struct {
+	Hits          uint64 // Calls served from the cache
+	Misses        uint64 // Calls sent to Irmin, including calls made while the watch is disconnected
+	Invalidations uint64 // Cached keys removed because they changed
+}
+
+// HitRate returns the fraction of calls served from the cache
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+type cachedRead struct {
+	value    []byte
+	notFound bool
+}
+
+// CachedConn is a Conn that serves Read, ReadString, Mem and List below a path from an in-memory cache. The cache is
+// kept up to date by a WatchPathResilient on the path. While the watch is disconnected the cache is cleared and all
+// calls are sent to Irmin. Other calls, and calls outside the path, are passed on to the Conn.
+//
+// Changes, including changes made through the CachedConn, are visible in the cache when they have been reported by
+// the watch.
+type CachedConn struct {
+	*Conn
+	path    Path
+	watcher *Watcher
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	mu    sync.Mutex
+	gen   uint64 // incremented when the cache is invalidated, results fetched before are not cached
+	reads map[string]cachedRead
+	mems  map[string]bool
+	lists map[string][]Path
+	stats CacheStats
+}
+
+// NewCachedConn creates a cache for the keys below path and starts watching for changes. Close must be called to
+// stop the watch.
+func NewCachedConn(conn *Conn, path Path) *CachedConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &CachedConn{
+		Conn:   conn,
+		path:   path,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	c.clear()
+	c.watcher = conn.WatchPathResilient(ctx, path, nil, Backoff{})
+	go c.run()
+	return c
+}
+
+// Close stops the watch and clears the cache
+func (c *CachedConn) Close() {
+	c.cancel()
+	<-c.done
+	c.mu.Lock()
+	c.clear()
+	c.mu.Unlock()
+}
+
+// Stats returns the cache counters
+func (c *CachedConn) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// clear removes all cached results. Must be called with c.mu held.
+func (c *CachedConn) clear() {
+	c.gen++
+	c.reads = make(map[string]cachedRead)
+	c.mems = make(map[string]bool)
+	c.lists = make(map[string][]Path)
+}
+
+// run invalidates changed keys until the watcher is stopped
+func (c *CachedConn) run() {
+	defer close(c.done)
+	events := c.watcher.Events
+	for {
+		select {
+		case ev := <-events:
+			if ev.State != WatchConnected { // changes may be missed, don't use the cache until reconnected
+				c.mu.Lock()
+				c.clear()
+				c.mu.Unlock()
+			}
+		case wc, ok := <-c.watcher.C:
+			if !ok {
+				return
+			}
+			c.mu.Lock()
+			c.gen++
+			for _, change := range wc.Changes {
+				c.invalidate(change.Key)
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// invalidate removes a key and the lists of its parents from the cache. Must be called with c.mu held.
+func (c *CachedConn) invalidate(key Path) {
+	k := key.String()
+	if _, ok := c.reads[k]; ok {
+		delete(c.reads, k)
+		c.stats.Invalidations++
+	}
+	delete(c.mems, k)
+	for i := 0; i < len(key); i++ {
+		parent := key[:i]
+		delete(c.lists, parent.String())
+	}
+}
+
+// lookup returns the current generation and true if calls for path can be served from the cache
+func (c *CachedConn) lookup(path Path) (uint64, bool) {
+	if !hasPathPrefix(path, c.path) || c.watcher.State() != WatchConnected {
+		c.stats.Misses++
+		return 0, false
+	}
+	return c.gen, true
+}
+
+// Read reads a key from the cache, or from Irmin if it is not cached
+func (c *CachedConn) Read(path Path) ([]byte, error) {
+	return c.ReadContext(context.Background(), path)
+}
+
+// ReadContext is like Read, but the request is aborted if ctx is cancelled.
+func (c *CachedConn) ReadContext(ctx context.Context, path Path) ([]byte, error) {
+	k := path.String()
+	c.mu.Lock()
+	gen, cacheable := c.lookup(path)
+	if r, ok := c.reads[k]; cacheable && ok {
+		c.stats.Hits++
+		c.mu.Unlock()
+		if r.notFound {
+			return []byte{}, fmt.Errorf("read %s: %w", k, ErrNotFound)
+		}
+		return append([]byte{}, r.value...), nil
+	}
+	if cacheable {
+		c.stats.Misses++
+	}
+	c.mu.Unlock()
+
+	v, err := c.Conn.ReadContext(ctx, path)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return v, err
+	}
+	c.mu.Lock()
+	if cacheable && gen == c.gen {
+		c.reads[k] = cachedRead{append([]byte{}, v...), err != nil}
+	}
+	c.mu.Unlock()
+	return v, err
+}
+
+// ReadString reads a key from the cache and converts it into a string, like Conn.ReadString
+func (c *CachedConn) ReadString(path Path) (string, error) {
+	return c.ReadStringContext(context.Background(), path)
+}
+
+// ReadStringContext is like ReadString, but the request is aborted if ctx is cancelled.
+func (c *CachedConn) ReadStringContext(ctx context.Context, path Path) (string, error) {
+	res, err := c.ReadContext(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	if utf8.Valid(res) {
+		return string(res), nil
+	}
+	return "", fmt.Errorf("path %s does not contain a valid utf8 string", path.String())
+}
+
+// Mem returns true if a path exists, using the cache if possible
+func (c *CachedConn) Mem(path Path) (bool, error) {
+	return c.MemContext(context.Background(), path)
+}
+
+// MemContext is like Mem, but the request is aborted if ctx is cancelled.
+func (c *CachedConn) MemContext(ctx context.Context, path Path) (bool, error) {
+	k := path.String()
+	c.mu.Lock()
+	gen, cacheable := c.lookup(path)
+	if b, ok := c.mems[k]; cacheable && ok {
+		c.stats.Hits++
+		c.mu.Unlock()
+		return b, nil
+	}
+	if r, ok := c.reads[k]; cacheable && ok {
+		c.stats.Hits++
+		c.mu.Unlock()
+		return !r.notFound, nil
+	}
+	if cacheable {
+		c.stats.Misses++
+	}
+	c.mu.Unlock()
+
+	b, err := c.Conn.MemContext(ctx, path)
+	if err != nil {
+		return b, err
+	}
+	c.mu.Lock()
+	if cacheable && gen == c.gen {
+		c.mems[k] = b
+	}
+	c.mu.Unlock()
+	return b, nil
+}
+
+// List returns the keys in a path, using the cache if possible
+func (c *CachedConn) List(path Path) ([]Path, error) {
+	return c.ListContext(context.Background(), path)
+}
+
+// ListContext is like List, but the request is aborted if ctx is cancelled.
+func (c *CachedConn) ListContext(ctx context.Context, path Path) ([]Path, error) {
+	k := path.String()
+	c.mu.Lock()
+	gen, cacheable := c.lookup(path)
+	if l, ok := c.lists[k]; cacheable && ok {
+		c.stats.Hits++
+		c.mu.Unlock()
+		return append([]Path{}, l...), nil
+	}
+	if cacheable {
+		c.stats.Misses++
+	}
+	c.mu.Unlock()
+
+	l, err := c.Conn.ListContext(ctx, path)
+	if err != nil {
+		return l, err
+	}
+	c.mu.Lock()
+	if cacheable && gen == c.gen {
+		c.lists[k] = append([]Path{}, l...)
+	}
+	c.mu.Unlock()
+	return l, nil
+}
@@ -0,0 +1,111 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MagnusS/irmin-go/irmin"
+)
+
+func TestCachedConn(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	r := getConn(t, srv)
+	key := irmin.ParsePath("/cache/a")
+	if _, err := r.Update(r.NewTask("update key"), key, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+
+	c := irmin.NewCachedConn(r, irmin.ParsePath("/cache"))
+	defer c.Close()
+
+	// eventually waits until f returns true
+	eventually := func(msg string, f func() bool) {
+		deadline := time.Now().Add(1 * time.Second)
+		for !f() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out while waiting for %s, stats %+v", msg, c.Stats())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	read := func(p irmin.Path) string {
+		v, err := c.ReadString(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	eventually("cache hit", func() bool {
+		hits := c.Stats().Hits
+		return read(key) == "foo" && read(key) == "foo" && c.Stats().Hits > hits
+	})
+
+	// Values returned from the cache must be copies
+	v, err := c.Read(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v[0] = 'X'
+	if v := read(key); v != "foo" {
+		t.Fatalf("cache changed by caller, got %s", v)
+	}
+
+	if _, err := r.Update(r.NewTask("update key"), key, []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	eventually("invalidation", func() bool { return read(key) == "bar" })
+	if c.Stats().Invalidations == 0 {
+		t.Fatal("expected invalidation")
+	}
+
+	// List and Mem of a new key
+	b := irmin.ParsePath("/cache/b")
+	if ok, err := c.Mem(b); err != nil || ok {
+		t.Fatalf("expected /cache/b to not exist (mem=%t, err=%v)", ok, err)
+	}
+	if l, err := c.List(irmin.ParsePath("/cache")); err != nil || len(l) != 1 {
+		t.Fatalf("expected one key in /cache, got %v (err=%v)", l, err)
+	}
+	if _, err := r.Update(r.NewTask("update key"), b, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	eventually("mem and list update", func() bool {
+		ok, err := c.Mem(b)
+		l, lerr := c.List(irmin.ParsePath("/cache"))
+		return err == nil && lerr == nil && ok && len(l) == 2
+	})
+
+	// Keys outside the path are not cached
+	other := irmin.ParsePath("/not-cached")
+	if _, err := r.Update(r.NewTask("update key"), other, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	hits := c.Stats().Hits
+	read(other)
+	read(other)
+	if c.Stats().Hits != hits {
+		t.Fatal("key outside the cached path was served from the cache")
+	}
+	if rate := c.Stats().HitRate(); rate <= 0 || rate >= 1 {
+		t.Fatalf("unexpected hit rate %f", rate)
+	}
+}
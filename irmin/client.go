@@ -18,63 +18,210 @@ package irmin
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"sync"
+	"time"
 )
 
 // Client contains basic state needed to connect to Irmin
 type Client struct {
-	baseURI *url.URL // Irmin base URI
-	log     Log      // Logger
+	baseURI    *url.URL     // Irmin base URI
+	logger     Logger       // Logger
+	redaction  *Redaction   // Values to hide in log messages
+	httpClient *http.Client // HTTP client used for all requests
+	userAgent  string       // User-Agent header, not set if empty
+	header     http.Header  // Extra headers added to every request
+	retry      *RetryPolicy // Retry policy, nil if calls are not retried
+
+	basicAuth   *url.Userinfo     // Basic auth credentials, from the URL or WithBasicAuth
+	tokenSource TokenSource       // Bearer tokens, used instead of basic auth if set
+	tlsConfig   *tls.Config       // TLS configuration from WithTLSConfig, replaces the one in the transport
+	clientCerts []tls.Certificate // Client certificates from WithClientCertificate, added to the TLS configuration
+	configErr   error             // Returned by every call if the options could not be applied
+
+	instrumentation Instrumentation // Receives measurements for calls and streams
+	tracer          Tracer          // Starts a span for every call and stream
+	middleware      []Middleware    // Wraps every call and stream, the first is the outermost
+
+	readConcurrency int   // Number of parallel reads in ReadTree
+	valueCodec      Codec // Codec used by Get and Put, JSONCodec if nil
 }
 
 // StreamReply contains one reply received from an Irmin stream
 type StreamReply struct {
 	Error  Value
 	Result json.RawMessage
+	Err    error `json:"-"` // Set if the stream failed. This is the last reply in the channel.
 }
 
-// NewClient creates a new client data structure
-func NewClient(uri *url.URL, log Log) *Client {
-	return &Client{uri, log}
+// err returns the stream error or the error returned by Irmin, or nil if the reply contains a result
+func (s *StreamReply) err() error {
+	if s.Err != nil {
+		return s.Err
+	}
+	if s.Error.String() != "" {
+		return &ServerError{Message: s.Error.String()}
+	}
+	return nil
 }
 
-// Call connects to the specified URL and attempts to unmarshal the reply. The result is stored in v.
-func (c *Client) Call(uri *url.URL, post *postRequest, v interface{}) (err error) {
-	c.log.Printf("calling: %s\n", uri.String())
-	var res *http.Response
-	if post == nil {
-		res, err = http.Get(uri.String())
-	} else {
-		j, err := json.Marshal(post)
-		if err != nil {
-			panic(err)
-		}
-		c.log.Printf("post body: %s\n", j)
-		res, err = http.Post(uri.String(), "application/json", bytes.NewBuffer(j))
+// streamError converts an error from decoding a stream. Parse errors are returned as *ProtocolError and a connection
+// closed before the end of the stream as io.ErrUnexpectedEOF.
+func streamError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return &ProtocolError{"unable to parse stream", err}
+	}
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
 	}
+	return err
+}
+
+// NewClient creates a new client data structure. Requests are sent with http.DefaultClient and log messages are
+// ignored unless other options are given. If uri contains a user name and password they are removed from the URL and
+// sent with basic auth.
+func NewClient(uri *url.URL, opts ...ClientOption) *Client {
+	c := &Client{
+		baseURI:    uri,
+		logger:     IgnoreLog{},
+		redaction:  &Redaction{},
+		httpClient: http.DefaultClient,
+		header:     make(http.Header),
+
+		instrumentation: IgnoreInstrumentation{},
+		tracer:          IgnoreTracer{},
+	}
+	if uri != nil && uri.User != nil {
+		u := *uri
+		c.basicAuth, u.User = u.User, nil
+		c.baseURI = &u
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.tlsConfig != nil || len(c.clientCerts) > 0 {
+		c.configErr = c.applyTLS()
+	}
+	return c
+}
+
+// Call connects to the specified URL and attempts to unmarshal the reply. The result is stored in v.
+func (c *Client) Call(uri *url.URL, post *postRequest, v interface{}) error {
+	return c.CallContext(context.Background(), uri, post, v)
+}
+
+// CallContext is like Call, but the request is aborted if ctx is cancelled before the reply is received.
+func (c *Client) CallContext(ctx context.Context, uri *url.URL, post *postRequest, v interface{}) error {
+	_, err := c.handler()(ctx, newCall(uri, post, v))
+	return err
+}
+
+// call sends a request with the extra headers in header and stores the reply in v
+func (c *Client) call(ctx context.Context, uri *url.URL, post *postRequest, header http.Header, v interface{}) (err error) {
+	ctx, span := c.startSpan(ctx, uri)
+	info := c.startRequest(uri, false)
+	var commit string
+	var body []byte
+	defer func() {
+		c.finishRequest(info, body, err)
+		finishSpan(span, *info, commit, err)
+	}()
+
+	res, err := c.send(ctx, uri, post, header, info)
 	if err != nil {
-		return
+		return err
 	}
 	defer res.Body.Close()
 	if res.StatusCode != 200 {
-		return fmt.Errorf("Irmin HTTP server returned status %#v", res.Status)
+		return statusError(res)
 	}
-	body, err := ioutil.ReadAll(res.Body)
+	body, err = ioutil.ReadAll(res.Body)
+	info.BytesReceived = int64(len(body))
 	if err != nil {
-		return
+		return err
 	}
-	c.log.Printf("returned: %s\n", body)
 
-	return json.Unmarshal(body, v)
+	if err = json.Unmarshal(body, v); err != nil {
+		return &ProtocolError{"unable to parse reply", err}
+	}
+	commit = replyCommit(info.Command, body)
+	return nil
+}
+
+// statusError returns a *HTTPStatusError with the status and the start of the body of res
+func statusError(res *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+	return &HTTPStatusError{StatusCode: res.StatusCode, Status: res.Status, Body: body}
+}
+
+// newRequest creates a GET request, or a POST request if body is set, with the headers in header
+func (c *Client) newRequest(ctx context.Context, uri *url.URL, body []byte, header http.Header) (*http.Request, error) {
+	method, r := "GET", io.Reader(nil)
+	if body != nil {
+		method, r = "POST", bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, uri.String(), r)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = append(req.Header[k], v...)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// do adds the configured headers to req and sends it with the configured http.Client
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	for k, v := range c.header {
+		req.Header[k] = append(req.Header[k], v...)
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	c.tracer.Inject(req.Context(), req.Header)
+	if err := c.authenticate(req); err != nil {
+		return nil, err
+	}
+	res, err := c.httpClient.Do(req)
+	if err == nil && res.StatusCode == http.StatusUnauthorized {
+		c.unauthorized()
+	}
+	return res, err
 }
 
 // CallStream connects to the given URL and returns a channel with responses until the stream is closed. The channel contains raw replies and must be unmarshaled by the caller.
+// If the stream fails, the last reply in the channel will have .Err set.
 func (c *Client) CallStream(uri *url.URL, post *postRequest) (<-chan *StreamReply, error) {
+	return c.CallStreamContext(context.Background(), uri, post)
+}
+
+// CallStreamContext is like CallStream, but the stream is closed when ctx is cancelled. The response body is then closed and
+// the channel is closed, even if nobody is reading from it.
+func (c *Client) CallStreamContext(ctx context.Context, uri *url.URL, post *postRequest) (<-chan *StreamReply, error) {
+	res, err := c.handler()(ctx, newStream(uri, post))
+	if err != nil {
+		return nil, err
+	}
+	if res == nil || res.Stream == nil {
+		return nil, &ProtocolError{Msg: "middleware returned no stream"}
+	}
+	return res.Stream, nil
+}
+
+// callStream starts a stream with the extra headers in header
+func (c *Client) callStream(ctx context.Context, uri *url.URL, post *postRequest, header http.Header) (_ <-chan *StreamReply, err error) {
 	var streamToken struct {
 		Stream Value
 	}
@@ -82,40 +229,42 @@ func (c *Client) CallStream(uri *url.URL, post *postRequest) (<-chan *StreamRepl
 		Version Value
 	}
 
-	var res *http.Response
-	var err error
-
-	if post == nil {
-		res, err = http.Get(uri.String())
-	} else {
-		j, err := json.Marshal(post)
-		if err != nil {
-			panic(err)
+	ctx, span := c.startSpan(ctx, uri)
+	info := c.startRequest(uri, true)
+	defer func() {
+		c.finishRequest(info, nil, err)
+		if err != nil { // the span is ended by the stream reader once it has been started
+			finishSpan(span, *info, "", err)
 		}
-		res, err = http.Post(uri.String(), "application/json", bytes.NewBuffer(j))
-	}
+	}()
+
+	res, err := c.send(ctx, uri, post, header, info)
 	if err != nil {
 		return nil, err
 	}
+	if res.StatusCode != 200 {
+		defer res.Body.Close()
+		return nil, statusError(res)
+	}
 
-	wg := sync.WaitGroup{}
-	wg.Add(1)
-	defer wg.Done()
-	go func() {
-		wg.Wait() // close when all readers are done
-		res.Body.Close()
+	streaming := false
+	defer func() {
+		if !streaming { // the body is closed by the stream reader once it has been started
+			res.Body.Close()
+		}
 	}()
 
-	dec := json.NewDecoder(res.Body)
+	body := &countingReader{r: res.Body}
+	dec := json.NewDecoder(body)
 	var t interface{}
 	if t, err = dec.Token(); err != nil { // read [ token
-		return nil, err
+		return nil, &ProtocolError{"unable to read stream", err}
 	}
 	switch t.(type) {
 	case json.Delim:
 		d := t.(json.Delim).String()
 		if d != "[" {
-			descr := fmt.Errorf("expected [, got %s", d) // If we are unable to unmarshal error msg, return this error
+			descr := &ProtocolError{Msg: fmt.Sprintf("expected [, got %s", d)} // If we are unable to unmarshal error msg, return this error
 			// Invalid format. Try to unmarshal error value, in case it was returned outside the stream
 			rest, err := ioutil.ReadAll(res.Body)
 			if err != nil {
@@ -128,45 +277,75 @@ func (c *Client) CallStream(uri *url.URL, post *postRequest) (<-chan *StreamRepl
 			if err != nil {
 				return nil, descr
 			}
-			if errormsg.Error != nil {
-				return nil, fmt.Errorf("Server returned an error: %s", errormsg.Error.String())
+			if err = errormsg.err(); err != nil {
+				return nil, err
 			}
 			return nil, descr
 		}
 	default:
-		err = fmt.Errorf("expected delimiter")
-		return nil, err
+		return nil, &ProtocolError{Msg: "expected delimiter"}
 	}
 
 	err = dec.Decode(&streamToken)
 	if err != nil || !bytes.Equal(streamToken.Stream, []byte("start")) { // look for stream start
-		return nil, err
+		return nil, &ProtocolError{"expected stream start", err}
 	}
 
 	err = dec.Decode(&version)
 	if err != nil {
-		return nil, err
+		return nil, &ProtocolError{"expected version", err}
 	}
 
 	ch := make(chan *StreamReply, 100)
-	wg.Add(1)
+	streaming = true
+
+	c.instrumentation.StreamOpened(info.Command)
+	stream := StreamInfo{Command: info.Command}
+	started := *info
+	opened := time.Now()
+
+	send := func(s *StreamReply) bool {
+		select {
+		case ch <- s:
+			return true
+		case <-ctx.Done():
+			stream.Err = ctx.Err()
+			return false
+		}
+	}
+
 	go func() {
 		defer func() {
+			res.Body.Close()
+			stream.BytesReceived = body.n
+			stream.Duration = time.Since(opened)
+			c.finishStream(ctx, stream)
+			finishSpan(span, started, "", stream.Err)
 			close(ch)
-			wg.Done()
 		}()
 
-		for dec.More() {
+		for {
+			if !dec.More() { // end of array, or the connection was closed
+				if _, err := dec.Token(); err != nil {
+					stream.Err = streamError(err)
+					send(&StreamReply{Err: stream.Err})
+				}
+				return
+			}
 			s := new(StreamReply)
-			if err = dec.Decode(s); err != nil {
+			if err := dec.Decode(s); err != nil {
+				stream.Err = streamError(err)
+				send(&StreamReply{Err: stream.Err})
 				return
 			}
-			if len(s.Result) == 0 { // If result is empty, look for stream end
-				if err = dec.Decode(&streamToken); err != nil || bytes.Equal(streamToken.Stream, []byte("end")) { // look for stream end
-					return
-				}
+			if len(s.Result) == 0 && len(s.Error) == 0 { // no result or error, this is the stream end token
+				return
+			}
+			stream.Items++
+			c.instrumentation.StreamItem(info.Command)
+			if !send(s) {
+				return
 			}
-			ch <- s
 		}
 	}()
 	return ch, nil
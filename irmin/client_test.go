@@ -0,0 +1,288 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCallStreamContextCancel(t *testing.T) {
+	closed := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(closed)
+		fmt.Fprint(w, `[{"stream":"start"},{"version":"0.10.0"}`)
+		for {
+			if _, err := fmt.Fprint(w, `,{"result":["a","b"]}`); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}))
+	defer srv.Close()
+
+	uri, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := Create(uri, "irmin-go-tester")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := conn.IterContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := <-ch; p.Path.String() != "/a/b" {
+		t.Fatalf("expected /a/b, got %s", p.Path.String())
+	}
+	cancel() // stop reading and cancel, the server should see the connection close
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out while waiting for stream to be closed")
+	}
+	for range ch { // channel must be closed
+	}
+}
+
+type recordingTransport struct {
+	requests []*http.Request
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.requests = append(rt.requests, req)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestClientOptions(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"result":[],"version":"0.10.0"}`)
+	}))
+	defer srv.Close()
+
+	uri, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := new(recordingTransport)
+	conn := Create(uri, "irmin-go-tester",
+		WithHTTPClient(&http.Client{Transport: rt}),
+		WithUserAgent("irmin-go-test/1.0"),
+		WithHeader("X-Tenant", "a"),
+		WithHeader("X-Tenant", "b"))
+
+	if _, err := conn.FromTree("dev").List(ParsePath("/a")); err != nil {
+		t.Fatal(err)
+	}
+	if len(rt.requests) != 1 {
+		t.Fatalf("expected 1 request through transport, got %d", len(rt.requests))
+	}
+	req := rt.requests[0]
+	if ua := req.Header.Get("User-Agent"); ua != "irmin-go-test/1.0" {
+		t.Fatalf("unexpected User-Agent %q", ua)
+	}
+	if h := req.Header["X-Tenant"]; len(h) != 2 || h[0] != "a" || h[1] != "b" {
+		t.Fatalf("unexpected X-Tenant header %v", h)
+	}
+}
+
+func TestStreamErrors(t *testing.T) {
+	replies := map[string]string{
+		"/iter":        `[{"stream":"start"},{"version":"0.10.0"},{"result":["a"]},{"result":{"not":"a path"}}`,
+		"/tree/x/iter": `[{"stream":"start"},{"version":"0.10.0"},{"result":["a"]},{"error":"something failed"},{"stream":"end"}]`,
+		"/tree/y/iter": `[{"stream":"start"},{"version":"0.10.0"},{"result":["a"]}`, // closed before stream end
+		"/watch/a":     `[{"stream":"start"},{"version":"0.10.0"},{"result":[["abcd","foo"]]},{"result":"garbage"}`,
+		"/watch-rec/a": `[{"stream":"start"},{"version":"0.10.0"},{"result":["abcd",[["+",["a","b"]]]]},{"result":[1,2]}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, replies[r.URL.Path])
+	}))
+	defer srv.Close()
+
+	uri, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := Create(uri, "irmin-go-tester")
+
+	for _, tree := range []string{"", "x", "y"} {
+		ch, err := conn.FromTree(tree).Iter()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := <-ch; r.Error != nil || r.Path.String() != "/a" {
+			t.Fatalf("expected /a, got %v", r)
+		}
+		if r := <-ch; r == nil || r.Error == nil {
+			t.Fatalf("expected error in tree %q, got %v", tree, r)
+		}
+		if _, ok := <-ch; ok {
+			t.Fatal("channel should be closed after error")
+		}
+	}
+
+	wch, err := conn.Watch(ParsePath("/a"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := <-wch; c.Error != nil || string(c.Value) != "foo" {
+		t.Fatalf("expected foo, got %v", c)
+	}
+	if c := <-wch; c == nil || c.Error == nil {
+		t.Fatalf("expected error from Watch, got %v", c)
+	}
+
+	pch, err := conn.WatchPath(ParsePath("/a"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := <-pch; c.Error != nil || len(c.Changes) != 1 {
+		t.Fatalf("expected one change, got %v", c)
+	}
+	if c := <-pch; c == nil || c.Error == nil {
+		t.Fatalf("expected error from WatchPath, got %v", c)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls[r.URL.Path]++
+		n := calls[r.URL.Path]
+		mu.Unlock()
+		if n < 3 { // fail twice
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		switch r.URL.Path {
+		case "/tree/x/iter":
+			fmt.Fprint(w, `[{"stream":"start"},{"version":"0.10.0"},{"result":["a"]},{"stream":"end"}]`)
+		case "/read/a", "/tree/a/b/read/a": // tree a/b is escaped as a%2Fb
+			fmt.Fprint(w, `{"result":["foo"],"version":"0.10.0"}`)
+		default:
+			fmt.Fprint(w, `{"result":"abcd","version":"0.10.0"}`)
+		}
+	}))
+	defer srv.Close()
+
+	uri, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := RetryPolicy{MaxAttempts: 3, Backoff: Backoff{Initial: time.Millisecond}}
+	conn := Create(uri, "irmin-go-tester", WithRetry(policy))
+
+	if _, err := conn.ReadString(ParsePath("/a")); err != nil {
+		t.Fatal(err)
+	}
+	ch, err := conn.FromTree("x").Iter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := <-ch; r.Error != nil || r.Path.String() != "/a" {
+		t.Fatalf("expected /a, got %v", r)
+	}
+	if _, err := conn.FromTree("a/b").ReadString(ParsePath("/a")); err != nil {
+		t.Fatal(err)
+	}
+
+	var status *HTTPStatusError
+	if _, err := conn.Update(conn.NewTask("update"), ParsePath("/a"), []byte("foo")); !errors.As(err, &status) {
+		t.Fatalf("expected update to fail without retry, got %v", err)
+	}
+	old, new := []byte("foo"), []byte("bar")
+	if _, err := conn.CompareAndSet(conn.NewTask("cas"), ParsePath("/a"), &old, &new); !errors.As(err, &status) {
+		t.Fatalf("expected compare-and-set to fail without RetryGuardedWrites, got %v", err)
+	}
+	policy.RetryGuardedWrites = true
+	if _, err := Create(uri, "irmin-go-tester", WithRetry(policy)).CompareAndSet(conn.NewTask("cas"), ParsePath("/b"), &old, &new); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expect := map[string]int{"/read/a": 3, "/tree/x/iter": 3, "/tree/a/b/read/a": 3, "/update/a": 1, "/compare-and-set/a": 1, "/compare-and-set/b": 3}
+	for p, n := range expect {
+		if calls[p] != n {
+			t.Errorf("expected %d calls to %s, got %d", n, p, calls[p])
+		}
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond, Multiplier: 2}
+	for attempt, d := range []time.Duration{10, 20, 40, 50, 50} {
+		if got := b.Delay(attempt); got != d*time.Millisecond {
+			t.Errorf("expected %s before attempt %d, got %s", d*time.Millisecond, attempt, got)
+		}
+	}
+	if d := (Backoff{Initial: time.Second}).Delay(3); d != time.Second {
+		t.Errorf("expected constant delay without multiplier, got %s", d)
+	}
+	if d := (Backoff{}).Delay(0); d > DefaultBackoff.Initial || d < 80*time.Millisecond {
+		t.Errorf("expected DefaultBackoff for the zero value, got %s", d)
+	}
+}
+
+func TestSplitCallURL(t *testing.T) {
+	for path, expect := range map[string][3]string{
+		"/":                                {"", "", "/"},
+		"/read/a/b":                        {"", "read", "/a/b"},
+		"/tree/dev/read/a":                 {"dev", "read", "/a"},
+		"/tree/dev/view/create/create":     {"dev", "view/create", "/"},
+		"/tree/dev/view/0001/merge-path/a": {"dev", "view/merge-path", "/a"},
+		"/view/create/create/a":            {"", "view/create", "/a"},
+		"/view/0001/iter":                  {"", "view/iter", "/"},
+		"/tree/feature%2Fx/read/a":         {"feature/x", "read", "/a"},
+	} {
+		u, err := url.Parse(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, command, p := splitCallURL(u)
+		if got := [3]string{tree, command, pathString(p)}; got != expect {
+			t.Errorf("expected %q for %s, got %q", expect, path, got)
+		}
+	}
+	u, _ := url.Parse("/read/a%2Fb/c%20d")
+	if _, _, p := splitCallURL(u); len(p) != 2 || string(p[0]) != "a/b" || string(p[1]) != "c d" {
+		t.Errorf("expected path [a/b c d], got %q", p)
+	}
+	base, _ := url.Parse("http://localhost:8080")
+	u, err := Create(base, "irmin-go-tester").FromTree("feature/x").MakeCallURL("read", ParsePath("/a"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree, command, p := splitCallURL(u); tree != "feature/x" || command != "read" || pathString(p) != "/a" {
+		t.Errorf("expected feature/x, read and /a for %s, got %s, %s and %s", u, tree, command, pathString(p))
+	}
+}
@@ -0,0 +1,157 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// Codec encodes and decodes values stored with Put and read with Get
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// ValueMarshaler is implemented by types that encode themselves. The codec is not used for these types.
+type ValueMarshaler interface {
+	MarshalValue() ([]byte, error)
+}
+
+// ValueUnmarshaler is implemented by types that decode themselves. The codec is not used for these types.
+type ValueUnmarshaler interface {
+	UnmarshalValue(data []byte) error
+}
+
+// DecodeError is returned by Get when a value could not be decoded
+type DecodeError struct {
+	Path Path
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("unable to decode %s: %s", e.Path.String(), e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+var (
+	// JSONCodec encodes values with encoding/json. This is the default codec.
+	JSONCodec Codec = jsonCodec{}
+	// GobCodec encodes values with encoding/gob
+	GobCodec Codec = gobCodec{}
+)
+
+// Store is implemented by Conn and View and is used by Get and Put
+type Store interface {
+	ReadContext(ctx context.Context, path Path) ([]byte, error)
+	UpdateContext(ctx context.Context, t Task, path Path, contents []byte) (string, error)
+	codec() Codec
+	context() context.Context
+}
+
+// codec returns the codec set with WithCodec, or JSONCodec
+func (c *Client) codec() Codec {
+	if c.valueCodec == nil {
+		return JSONCodec
+	}
+	return c.valueCodec
+}
+
+func (view *View) codec() Codec {
+	return view.srv.codec()
+}
+
+// context returns the context used by Get and Put. See View.context.
+func (c *Client) context() context.Context {
+	return context.Background()
+}
+
+// Get reads a value from a Conn or a View and decodes it with the codec set with WithCodec. A *DecodeError is
+// returned if the value could not be decoded. Reads from a View are traced like View.Read.
+func Get[T any](s Store, path Path) (T, error) {
+	return GetContext[T](s.context(), s, path)
+}
+
+// GetContext is like Get, but the request is aborted if ctx is cancelled.
+func GetContext[T any](ctx context.Context, s Store, path Path) (T, error) {
+	var v T
+	data, err := s.ReadContext(ctx, path)
+	if err != nil {
+		return v, err
+	}
+	if u, ok := interface{}(&v).(ValueUnmarshaler); ok {
+		err = u.UnmarshalValue(data)
+	} else {
+		err = s.codec().Unmarshal(data, &v)
+	}
+	if err != nil {
+		return v, &DecodeError{path, err}
+	}
+	return v, nil
+}
+
+// Put encodes a value with the codec set with WithCodec and stores it in a Conn or a View. Returns the result of
+// Update. Updates of a View are traced like View.Update.
+func Put[T any](s Store, t Task, path Path, v T) (string, error) {
+	return PutContext(s.context(), s, t, path, v)
+}
+
+// PutContext is like Put, but the request is aborted if ctx is cancelled.
+func PutContext[T any](ctx context.Context, s Store, t Task, path Path, v T) (string, error) {
+	var data []byte
+	var err error
+	if m, ok := interface{}(v).(ValueMarshaler); ok {
+		data, err = m.MarshalValue()
+	} else {
+		data, err = s.codec().Marshal(v)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to encode %s: %w", path.String(), err)
+	}
+	return s.UpdateContext(ctx, t, path, data)
+}
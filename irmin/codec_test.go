@@ -0,0 +1,96 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/MagnusS/irmin-go/irmin"
+)
+
+type codecConfig struct {
+	Name  string
+	Ports []int
+}
+
+// upper is stored as an upper case string without using the codec
+type upper string
+
+func (u upper) MarshalValue() ([]byte, error) {
+	return []byte(strings.ToUpper(string(u))), nil
+}
+
+func (u *upper) UnmarshalValue(data []byte) error {
+	*u = upper(strings.ToLower(string(data)))
+	return nil
+}
+
+func TestCodecs(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	cfg := codecConfig{"web", []int{80, 443}}
+	for _, codec := range []irmin.Codec{irmin.JSONCodec, irmin.GobCodec} {
+		r := srv.Conn("irmin-go-tester", irmin.WithCodec(codec))
+		path := irmin.ParsePath("/codec/config")
+		if _, err := irmin.Put(r, r.NewTask("put"), path, cfg); err != nil {
+			t.Fatal(err)
+		}
+		v, err := irmin.Get[codecConfig](r, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Name != cfg.Name || len(v.Ports) != 2 || v.Ports[1] != 443 {
+			t.Fatalf("unexpected value %v", v)
+		}
+
+		view, err := r.CreateView(r.NewTask("create view"), irmin.ParsePath("/codec"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, err = irmin.Get[codecConfig](view, irmin.ParsePath("/config")); err != nil || v.Name != cfg.Name {
+			t.Fatalf("unexpected value in view %v (err=%v)", v, err)
+		}
+		if _, err := irmin.Put(view, r.NewTask("put"), irmin.ParsePath("/count"), 42); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := irmin.Get[int](view, irmin.ParsePath("/count")); err != nil || n != 42 {
+			t.Fatalf("expected 42, got %d (err=%v)", n, err)
+		}
+	}
+
+	r := getConn(t, srv)
+	if _, err := irmin.Put(r, r.NewTask("put"), irmin.ParsePath("/codec/upper"), upper("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := r.ReadString(irmin.ParsePath("/codec/upper")); err != nil || s != "HELLO" {
+		t.Fatalf("expected MarshalValue to be used, got %s (err=%v)", s, err)
+	}
+	if u, err := irmin.Get[upper](r, irmin.ParsePath("/codec/upper")); err != nil || u != "hello" {
+		t.Fatalf("expected UnmarshalValue to be used, got %s (err=%v)", u, err)
+	}
+
+	var decodeErr *irmin.DecodeError
+	if _, err := irmin.Get[codecConfig](r, irmin.ParsePath("/codec/upper")); !errors.As(err, &decodeErr) || decodeErr.Path.String() != "/codec/upper" {
+		t.Fatalf("expected DecodeError for /codec/upper, got %v", err)
+	}
+	if _, err := irmin.Get[codecConfig](r, irmin.ParsePath("/codec/missing")); !errors.Is(err, irmin.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Commit contains a commit as returned by Commit and Log
+type Commit struct {
+	Hash    []byte
+	Parents [][]byte
+	Task    Task // Author, date and commit message
+}
+
+// LogResult contains one commit returned by Log
+type LogResult struct {
+	Commit *Commit
+	Error  error // Only set if an error occurred. This is the last item in the channel.
+}
+
+type commitInfo struct {
+	Hash    Value   `json:"hash"`
+	Parents []Value `json:"parents"`
+	Task    Task    `json:"task"`
+}
+
+type commitReply struct {
+	ErrorVersion
+	Result *commitInfo
+}
+
+// Time returns the commit date of a task
+func (t *Task) Time() (time.Time, error) {
+	s, err := strconv.ParseInt(t.Date, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid task date %s: %w", t.Date, err)
+	}
+	return time.Unix(s, 0), nil
+}
+
+// Message returns the commit messages of a task, one per line
+func (t *Task) Message() string {
+	msgs := make([]string, len(t.Messages))
+	for i := range t.Messages {
+		msgs[i] = t.Messages[i].String()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Commit returns the parents and task of a commit. Returns ErrNotFound if the commit does not exist.
+func (rest *Conn) Commit(hash []byte) (*Commit, error) {
+	return rest.CommitContext(context.Background(), hash)
+}
+
+// CommitContext is like Commit, but the request is aborted if ctx is cancelled.
+func (rest *Conn) CommitContext(ctx context.Context, hash []byte) (*Commit, error) {
+	var data commitReply
+	uri, err := rest.MakeCallURL("commit", hashPath(hash), false)
+	if err != nil {
+		return nil, err
+	}
+	if err = rest.CallContext(ctx, uri, nil, &data); err != nil {
+		return nil, err
+	}
+	if err = data.err(); err != nil {
+		return nil, err
+	}
+	if data.Result == nil {
+		return nil, fmt.Errorf("commit %x: %w", hash, ErrNotFound)
+	}
+
+	c := &Commit{Task: data.Result.Task}
+	if c.Hash, err = hex.DecodeString(data.Result.Hash.String()); err != nil {
+		return nil, &ProtocolError{fmt.Sprintf("unable to parse hash %s", data.Result.Hash.String()), err}
+	}
+	for _, p := range data.Result.Parents {
+		h, err := hex.DecodeString(p.String())
+		if err != nil {
+			return nil, &ProtocolError{fmt.Sprintf("unable to parse hash %s", p.String()), err}
+		}
+		c.Parents = append(c.Parents, h)
+	}
+	return c, nil
+}
+
+// History returns up to max commits from the head of the current tree, see Log. If max is 0 or less, all commits are
+// returned.
+func (rest *Conn) History(max int) ([]*Commit, error) {
+	return rest.HistoryContext(context.Background(), max)
+}
+
+// HistoryContext is like History, but the requests are aborted if ctx is cancelled.
+func (rest *Conn) HistoryContext(ctx context.Context, max int) ([]*Commit, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // stop Log when max is reached
+	ch, err := rest.LogContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var commits []*Commit
+	for r := range ch {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		commits = append(commits, r.Commit)
+		if max > 0 && len(commits) >= max {
+			break
+		}
+	}
+	return commits, nil
+}
+
+// Log walks the history from the head of the current tree and returns the commits in a channel, newest first. Use
+// FromTree to start from another branch or a commit hash. Commits with more than one parent are followed breadth
+// first and every commit is only returned once. On error, the last item in the channel will have .Error set - the
+// channel is then closed.
+func (rest *Conn) Log() (<-chan *LogResult, error) {
+	return rest.LogContext(context.Background())
+}
+
+// LogContext is like Log, but the walk is stopped and the channel closed when ctx is cancelled.
+func (rest *Conn) LogContext(ctx context.Context) (<-chan *LogResult, error) {
+	head, err := rest.HeadContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan *LogResult, 1)
+	send := func(r *LogResult) bool {
+		select {
+		case out <- r:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	go func() {
+		defer close(out)
+		if head == nil { // empty tree
+			return
+		}
+		queue := [][]byte{head}
+		seen := map[string]bool{string(head): true}
+		for len(queue) > 0 {
+			c, err := rest.CommitContext(ctx, queue[0])
+			queue = queue[1:]
+			if err != nil {
+				if ctx.Err() == nil {
+					send(&LogResult{Error: err})
+				}
+				return
+			}
+			if !send(&LogResult{Commit: c}) {
+				return
+			}
+			for _, p := range c.Parents {
+				if !seen[string(p)] {
+					seen[string(p)] = true
+					queue = append(queue, p)
+				}
+			}
+		}
+	}()
+	return out, nil
+}
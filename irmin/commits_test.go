@@ -0,0 +1,83 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/MagnusS/irmin-go/irmin"
+)
+
+func TestHistory(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	r := getConn(t, srv)
+	if c, err := r.History(0); err != nil || len(c) != 0 {
+		t.Fatalf("expected empty history, got %v %v", c, err)
+	}
+
+	for _, msg := range []string{"first", "second", "third"} {
+		if _, err := r.Update(r.NewTask(msg), irmin.ParsePath("/history/a"), []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	head, err := r.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := r.Commit(head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(c.Hash, head) || len(c.Parents) != 1 {
+		t.Fatalf("unexpected commit %x with parents %x", c.Hash, c.Parents)
+	}
+	if c.Task.Message() != "third" || c.Task.Owner.String() != "irmin-go-tester" {
+		t.Fatalf("unexpected task %v", c.Task)
+	}
+	if _, err := c.Task.Time(); err != nil {
+		t.Fatal(err)
+	}
+
+	commits, err := r.History(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 3 {
+		t.Fatalf("expected 3 commits, got %d", len(commits))
+	}
+	for i, msg := range []string{"third", "second", "first"} {
+		if commits[i].Task.Message() != msg {
+			t.Fatalf("expected %s at position %d, got %s", msg, i, commits[i].Task.Message())
+		}
+	}
+	if len(commits[2].Parents) != 0 {
+		t.Fatal("first commit should not have parents")
+	}
+
+	if commits, err = r.History(2); err != nil || len(commits) != 2 {
+		t.Fatalf("expected 2 commits, got %d %v", len(commits), err)
+	}
+
+	if _, err := r.Commit([]byte{1, 2, 3}); !errors.Is(err, irmin.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for unknown commit, got %v", err)
+	}
+}
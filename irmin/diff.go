@@ -0,0 +1,99 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin
+
+import (
+	"bytes"
+	"context"
+	"sort"
+)
+
+// DiffChange contains a key that differs between two commits, as returned by Diff
+type DiffChange struct {
+	WatchPathChange
+	Old []byte // Value in the first commit, nil if the key was created
+	New []byte // Value in the second commit, nil if the key was deleted
+}
+
+// Diff returns the keys that were created, updated or deleted between two commits, sorted by path. A nil commit is
+// treated as an empty tree.
+func (rest *Conn) Diff(from []byte, to []byte) ([]DiffChange, error) {
+	return rest.DiffContext(context.Background(), from, to)
+}
+
+// DiffContext is like Diff, but the requests are aborted if ctx is cancelled.
+func (rest *Conn) DiffContext(ctx context.Context, from []byte, to []byte) ([]DiffChange, error) {
+	old, err := rest.snapshot(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := rest.snapshot(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []DiffChange
+	for k, o := range old {
+		c, ok := cur[k]
+		switch {
+		case !ok:
+			changes = append(changes, DiffChange{WatchPathChange{KeyDeleted, o.path}, o.value, nil})
+		case !bytes.Equal(o.value, c.value):
+			changes = append(changes, DiffChange{WatchPathChange{KeyUpdated, o.path}, o.value, c.value})
+		}
+	}
+	for k, c := range cur {
+		if _, ok := old[k]; !ok {
+			changes = append(changes, DiffChange{WatchPathChange{KeyCreated, c.path}, nil, c.value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key.String() < changes[j].Key.String()
+	})
+	return changes, nil
+}
+
+// DiffTrees is like Diff, but compares the current heads of two branches
+func (rest *Conn) DiffTrees(from string, to string) ([]DiffChange, error) {
+	return rest.DiffTreesContext(context.Background(), from, to)
+}
+
+// DiffTreesContext is like DiffTrees, but the requests are aborted if ctx is cancelled.
+func (rest *Conn) DiffTreesContext(ctx context.Context, from string, to string) ([]DiffChange, error) {
+	fromHead, err := rest.FromTree(from).HeadContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	toHead, err := rest.FromTree(to).HeadContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return rest.DiffContext(ctx, fromHead, toHead)
+}
+
+// snapshot reads all keys at a commit, indexed by path string
+func (rest *Conn) snapshot(ctx context.Context, commit []byte) (map[string]treeEntry, error) {
+	entries, err := rest.readTreeAt(ctx, commit, nil)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]treeEntry, len(entries))
+	for _, e := range entries {
+		m[e.path.String()] = e
+	}
+	return m, nil
+}
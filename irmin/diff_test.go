@@ -0,0 +1,77 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin_test
+
+import (
+	"testing"
+
+	"github.com/MagnusS/irmin-go/irmin"
+)
+
+func TestDiff(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	r := getConn(t, srv)
+	for _, k := range []string{"/diff/a", "/diff/b"} {
+		if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath(k), []byte("foo")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	from := mustHead(t, r)
+	if err := r.Clone(r.NewTask("clone"), "staging", false); err != nil {
+		t.Fatal(err)
+	}
+	staging := r.FromTree("staging")
+	if _, err := staging.Update(r.NewTask("update key"), irmin.ParsePath("/diff/a"), []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if err := staging.Remove(r.NewTask("remove key"), irmin.ParsePath("/diff/b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := staging.Update(r.NewTask("update key"), irmin.ParsePath("/diff/c"), []byte("baz")); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []struct {
+		change, key, old, new string
+	}{
+		{irmin.KeyUpdated, "/diff/a", "foo", "bar"},
+		{irmin.KeyDeleted, "/diff/b", "foo", ""},
+		{irmin.KeyCreated, "/diff/c", "", "baz"},
+	}
+	check := func(changes []irmin.DiffChange, err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != len(expect) {
+			t.Fatalf("expected %d changes, got %v", len(expect), changes)
+		}
+		for i, e := range expect {
+			c := changes[i]
+			if c.Change != e.change || c.Key.String() != e.key || string(c.Old) != e.old || string(c.New) != e.new {
+				t.Fatalf("expected %v, got %s %s %q %q", e, c.Change, c.Key.String(), c.Old, c.New)
+			}
+		}
+	}
+	check(r.Diff(from, mustHead(t, staging)))
+	check(r.DiffTrees("master", "staging"))
+
+	if changes, err := r.Diff(from, from); err != nil || len(changes) != 0 {
+		t.Fatalf("expected no changes, got %v %v", changes, err)
+	}
+}
@@ -0,0 +1,110 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotFound is returned when reading a key that does not exist
+	ErrNotFound = errors.New("key not found")
+	// ErrConflict is returned when a merge or compare-and-set fails. The actual error is a *ConflictError.
+	ErrConflict = errors.New("conflict")
+)
+
+// ServerError is an error message returned by Irmin
+type ServerError struct {
+	Version string // Irmin version
+	Message string // Error message as returned by Irmin
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("irmin error: %s", e.Message)
+}
+
+// HTTPStatusError is returned when Irmin replies with an HTTP status other than 200 OK
+type HTTPStatusError struct {
+	StatusCode int
+	Status     string
+	Body       []byte
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("Irmin HTTP server returned status %#v", e.Status)
+}
+
+// ProtocolError is returned when a reply from Irmin could not be parsed
+type ProtocolError struct {
+	Msg string
+	Err error // Underlying error, may be nil
+}
+
+func (e *ProtocolError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("invalid reply from Irmin: %s: %s", e.Msg, e.Err)
+	}
+	return fmt.Sprintf("invalid reply from Irmin: %s", e.Msg)
+}
+
+// Unwrap returns the underlying error
+func (e *ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+// ConflictError is returned when a merge or compare-and-set fails because of a conflict. errors.Is(err, ErrConflict)
+// is true for all conflicts.
+type ConflictError struct {
+	Message string // Conflict description returned by Irmin
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conflict: %s", e.Message)
+}
+
+// Is returns true if target is ErrConflict
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// err returns a *ServerError if Irmin returned an error, otherwise nil
+func (e *ErrorVersion) err() error {
+	if e.Error.String() == "" {
+		return nil
+	}
+	return &ServerError{Version: e.Version.String(), Message: e.Error.String()}
+}
+
+// mergeResult returns a *ConflictError if a merge result contains a conflict. Merge results are encoded as
+// {"ok": ...} or {"conflict": "description"}.
+func mergeResult(result json.RawMessage) error {
+	var r struct {
+		Conflict *Value `json:"conflict"`
+	}
+	if len(result) == 0 || string(result) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(result, &r); err != nil {
+		return &ProtocolError{"unable to parse merge result", err}
+	}
+	if r.Conflict != nil {
+		return &ConflictError{r.Conflict.String()}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/MagnusS/irmin-go/irmin"
+)
+
+func TestErrors(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	r := getConn(t, srv)
+	path := irmin.ParsePath("/errors/a")
+
+	if _, err := r.Read(path); !errors.Is(err, irmin.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for missing key, got %v", err)
+	}
+
+	if _, err := r.Update(r.NewTask("update key"), path, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	old, new := []byte("bar"), []byte("baz")
+	_, err := r.CompareAndSet(r.NewTask("compare-and-set"), path, &old, &new)
+	var conflict *irmin.ConflictError
+	if !errors.Is(err, irmin.ErrConflict) || !errors.As(err, &conflict) {
+		t.Fatalf("expected ErrConflict for compare-and-set with wrong value, got %v", err)
+	}
+	old = []byte("foo")
+	if _, err := r.CompareAndSet(r.NewTask("compare-and-set"), path, &old, &new); err != nil {
+		t.Fatal(err)
+	}
+
+	uri, err := r.MakeCallURL("no-such-command", irmin.Path{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status *irmin.HTTPStatusError
+	if err := r.Call(uri, nil, &struct{}{}); !errors.As(err, &status) || status.StatusCode != 404 {
+		t.Fatalf("expected HTTPStatusError with status 404, got %v", err)
+	}
+}
+
+func TestViewMergeConflict(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	r := getConn(t, srv)
+	path := irmin.ParsePath("/conflict/a")
+	if _, err := r.Update(r.NewTask("update key"), path, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	v, err := r.CreateView(r.NewTask("create view"), irmin.ParsePath("/conflict"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Read(irmin.ParsePath("/missing")); !errors.Is(err, irmin.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for missing key in view, got %v", err)
+	}
+	if _, err := v.Update(v.NewTask("update in view"), irmin.ParsePath("/a"), []byte("from view")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Update(r.NewTask("update key"), path, []byte("from master")); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.MergePath(v.NewTask("merge"), "master", irmin.ParsePath("/conflict")); !errors.Is(err, irmin.ErrConflict) {
+		t.Fatalf("expected ErrConflict when merging conflicting view, got %v", err)
+	}
+}
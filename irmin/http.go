@@ -17,6 +17,7 @@
 package irmin
 
 import (
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -60,10 +61,17 @@ type Task struct {
 	Messages []Value `json:"messages"`
 }
 
-// CommitValuePair represents the value of a key at a specific commit
+// CommitValuePair represents the value of a key at a specific commit, as returned by Watch
 type CommitValuePair struct {
 	Commit []byte
 	Value  []byte
+	Error  error // Only set if an error occurred and the watch needs to be restarted
+}
+
+// IterResult contains one path returned by Iter
+type IterResult struct {
+	Path  Path
+	Error error // Only set if an error occurred. This is the last item in the channel.
 }
 
 const (
@@ -102,25 +110,39 @@ type updateReply stringReply
 type removeReply stringReply
 type removeRecReply stringReply
 type headReply stringArrayReply
+type watchKeyReply [][]Value // An array of arrays of commit/value pairs
+
+type mergeReply struct {
+	ErrorVersion
+	Result json.RawMessage
+}
+
+type casReply mergeReply
 
 // Conn is an Irmin REST API connection
 type Conn struct {
 	Client
 	tree      string
 	taskowner string
+	txRetries int // Number of times Transaction is retried on conflict
 }
 
-// Create an Irmin REST HTTP connection data structure
-func Create(uri *url.URL, taskowner string) *Conn {
+// Create an Irmin REST HTTP connection data structure. The options are passed on to NewClient.
+func Create(uri *url.URL, taskowner string, opts ...ClientOption) *Conn {
 	r := new(Conn)
-	r.Client = *NewClient(uri, IgnoreLog{})
+	r.Client = *NewClient(uri, opts...)
 	r.taskowner = taskowner
 	return r
 }
 
-// SetLog sets the log implementation. Log messages are ignored by default.
+// SetLog sets the log implementation. All messages are written to log. Log messages are ignored by default.
 func (rest *Conn) SetLog(log Log) {
-	rest.log = log
+	rest.logger = NewLogAdapter(log, LevelDebug)
+}
+
+// SetLogger sets the structured logger. Log messages are ignored by default.
+func (rest *Conn) SetLogger(logger Logger) {
+	rest.logger = logger
 }
 
 // FromTree returns new Conn with a new tree position. An empty tree value defaults to master branch.
@@ -145,6 +167,12 @@ func (rest *Conn) SetTaskOwner(owner string) {
 	rest.taskowner = owner
 }
 
+// SetTransactionRetries sets how many times Transaction retries the transaction when the merge fails with a conflict.
+// Transactions are not retried by default.
+func (rest *Conn) SetTransactionRetries(n int) {
+	rest.txRetries = n
+}
+
 // NewTask creates a new task (commit message) that can be be submitted with a command
 func NewTask(taskowner string, message string) Task {
 	var t Task
@@ -184,6 +212,11 @@ func (rest *Conn) MakeCallURL(command string, path Path, supportsTree bool) (*ur
 
 // AvailableCommands queries Irmin for a list of available commands
 func (rest *Conn) AvailableCommands() ([]string, error) {
+	return rest.AvailableCommandsContext(context.Background())
+}
+
+// AvailableCommandsContext is like AvailableCommands, but the request is aborted if ctx is cancelled.
+func (rest *Conn) AvailableCommandsContext(ctx context.Context) ([]string, error) {
 	var data commandsReply
 
 	uri, err := rest.MakeCallURL("", Path{}, true)
@@ -191,11 +224,11 @@ func (rest *Conn) AvailableCommands() ([]string, error) {
 		return []string{}, err
 	}
 
-	if err = rest.Call(uri, nil, &data); err != nil {
+	if err = rest.CallContext(ctx, uri, nil, &data); err != nil {
 		return []string{}, err
 	}
-	if data.Error.String() != "" {
-		return []string{}, fmt.Errorf(data.Error.String())
+	if err = data.err(); err != nil {
+		return []string{}, err
 	}
 
 	r := make([]string, len(data.Result))
@@ -207,17 +240,22 @@ func (rest *Conn) AvailableCommands() ([]string, error) {
 
 // Version returns the Irmin version
 func (rest *Conn) Version() (string, error) {
+	return rest.VersionContext(context.Background())
+}
+
+// VersionContext is like Version, but the request is aborted if ctx is cancelled.
+func (rest *Conn) VersionContext(ctx context.Context) (string, error) {
 	var data commandsReply
 	var err error
 	uri, err := rest.MakeCallURL("", Path{}, true)
 	if err != nil {
 		return "", err
 	}
-	if err = rest.Call(uri, nil, &data); err != nil {
+	if err = rest.CallContext(ctx, uri, nil, &data); err != nil {
 		return "", err
 	}
-	if data.Error.String() != "" {
-		return "", fmt.Errorf(data.Error.String())
+	if err = data.err(); err != nil {
+		return "", err
 	}
 
 	return data.Version.String(), nil
@@ -225,16 +263,21 @@ func (rest *Conn) Version() (string, error) {
 
 // List returns a list of keys in a path
 func (rest *Conn) List(path Path) ([]Path, error) {
+	return rest.ListContext(context.Background(), path)
+}
+
+// ListContext is like List, but the request is aborted if ctx is cancelled.
+func (rest *Conn) ListContext(ctx context.Context, path Path) ([]Path, error) {
 	var data listReply
 	uri, err := rest.MakeCallURL("list", path, true)
 	if err != nil {
 		return []Path{}, err
 	}
-	if err = rest.Call(uri, nil, &data); err != nil {
+	if err = rest.CallContext(ctx, uri, nil, &data); err != nil {
 		return []Path{}, err
 	}
-	if data.Error.String() != "" {
-		return []Path{}, fmt.Errorf(data.Error.String())
+	if err = data.err(); err != nil {
+		return []Path{}, err
 	}
 
 	return data.Result, nil
@@ -242,74 +285,91 @@ func (rest *Conn) List(path Path) ([]Path, error) {
 
 // Mem returns true if a path exists
 func (rest *Conn) Mem(path Path) (bool, error) {
+	return rest.MemContext(context.Background(), path)
+}
+
+// MemContext is like Mem, but the request is aborted if ctx is cancelled.
+func (rest *Conn) MemContext(ctx context.Context, path Path) (bool, error) {
 	var data memReply
 	uri, err := rest.MakeCallURL("mem", path, true)
 	if err != nil {
 		return false, err
 	}
-	if err = rest.Call(uri, nil, &data); err != nil {
+	if err = rest.CallContext(ctx, uri, nil, &data); err != nil {
 		return false, err
 	}
-	if data.Error.String() != "" {
-		return false, fmt.Errorf(data.Error.String())
+	if err = data.err(); err != nil {
+		return false, err
 	}
 	return data.Result, nil
 }
 
 // Head returns the commit hash of HEAD. Returns nil if no current HEAD (db is empty)
 func (rest *Conn) Head() ([]byte, error) {
+	return rest.HeadContext(context.Background())
+}
+
+// HeadContext is like Head, but the request is aborted if ctx is cancelled.
+func (rest *Conn) HeadContext(ctx context.Context) ([]byte, error) {
 	var data headReply
 	uri, err := rest.MakeCallURL("head", nil, true)
 	if err != nil {
 		return []byte{}, err
 	}
-	if err = rest.Call(uri, nil, &data); err != nil {
+	if err = rest.CallContext(ctx, uri, nil, &data); err != nil {
 		return []byte{}, err
 	}
-	if data.Error.String() != "" {
-		return []byte{}, fmt.Errorf("irmin error: %s", data.Error.String())
+	if err = data.err(); err != nil {
+		return []byte{}, err
 	}
 	if len(data.Result) > 1 {
-		return []byte{}, fmt.Errorf("head returned more than one result")
+		return []byte{}, &ProtocolError{Msg: "head returned more than one result"}
 	}
 	if len(data.Result) == 1 {
 		hash, err := hex.DecodeString(data.Result[0].String())
 		if err != nil {
-			return []byte{}, fmt.Errorf("Unable to parse hash from Irmin: %s", data.Result[0])
+			return []byte{}, &ProtocolError{fmt.Sprintf("unable to parse hash %s", data.Result[0].String()), err}
 		}
 		return hash, nil
 	}
-	if len(data.Result) == 0 {
-		return nil, nil
-	}
-	return []byte{}, fmt.Errorf("Invalid data from Irmin.")
+	return nil, nil
 }
 
 // Read key value as byte array
 func (rest *Conn) Read(path Path) ([]byte, error) {
+	return rest.ReadContext(context.Background(), path)
+}
+
+// ReadContext is like Read, but the request is aborted if ctx is cancelled.
+func (rest *Conn) ReadContext(ctx context.Context, path Path) ([]byte, error) {
 	var data readReply
 	uri, err := rest.MakeCallURL("read", path, true)
 	if err != nil {
 		return []byte{}, err
 	}
-	if err = rest.Call(uri, nil, &data); err != nil {
+	if err = rest.CallContext(ctx, uri, nil, &data); err != nil {
 		return []byte{}, err
 	}
-	if data.Error.String() != "" {
-		return []byte{}, fmt.Errorf(data.Error.String())
+	if err = data.err(); err != nil {
+		return []byte{}, err
 	}
 	if len(data.Result) > 1 {
-		return []byte{}, fmt.Errorf("read %s returned more than one result", path.String())
+		return []byte{}, &ProtocolError{Msg: fmt.Sprintf("read %s returned more than one result", path.String())}
 	}
 	if len(data.Result) == 1 {
 		return data.Result[0], nil
 	}
-	return []byte{}, fmt.Errorf("invalid key %s", path.String())
+	return []byte{}, fmt.Errorf("read %s: %w", path.String(), ErrNotFound)
 }
 
 // ReadString reads a value as string. The value must contain a valid UTF-8 encoded string.
 func (rest *Conn) ReadString(path Path) (string, error) {
-	res, err := rest.Read(path)
+	return rest.ReadStringContext(context.Background(), path)
+}
+
+// ReadStringContext is like ReadString, but the request is aborted if ctx is cancelled.
+func (rest *Conn) ReadStringContext(ctx context.Context, path Path) (string, error) {
+	res, err := rest.ReadContext(ctx, path)
 	if err != nil {
 		return "", err
 	}
@@ -321,6 +381,11 @@ func (rest *Conn) ReadString(path Path) (string, error) {
 
 // Update a key. Returns hash as string on success.
 func (rest *Conn) Update(t Task, path Path, contents []byte) (string, error) {
+	return rest.UpdateContext(context.Background(), t, path, contents)
+}
+
+// UpdateContext is like Update, but the request is aborted if ctx is cancelled.
+func (rest *Conn) UpdateContext(ctx context.Context, t Task, path Path, contents []byte) (string, error) {
 	var data updateReply
 	var err error
 
@@ -338,14 +403,14 @@ func (rest *Conn) Update(t Task, path Path, contents []byte) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	if err = rest.Call(uri, &body, &data); err != nil {
+	if err = rest.CallContext(ctx, uri, &body, &data); err != nil {
 		return data.Result.String(), err
 	}
-	if data.Error.String() != "" {
-		return "", fmt.Errorf(data.Error.String())
+	if err = data.err(); err != nil {
+		return "", err
 	}
 	if data.Result.String() == "" {
-		return "", fmt.Errorf("update seemed to succeed, but didn't return a hash", path.String(), data.Result.String())
+		return "", &ProtocolError{Msg: fmt.Sprintf("update %s seemed to succeed, but didn't return a hash", path.String())}
 	}
 
 	return data.Result.String(), nil
@@ -353,75 +418,113 @@ func (rest *Conn) Update(t Task, path Path, contents []byte) (string, error) {
 
 // Remove key
 func (rest *Conn) Remove(t Task, path Path) error {
+	return rest.RemoveContext(context.Background(), t, path)
+}
+
+// RemoveContext is like Remove, but the request is aborted if ctx is cancelled.
+func (rest *Conn) RemoveContext(ctx context.Context, t Task, path Path) error {
 	var data removeReply
 	uri, err := rest.MakeCallURL("remove", path, true)
 	if err != nil {
 		return err
 	}
 	body := postRequest{t, nil}
-	if err = rest.Call(uri, &body, &data); err != nil {
+	if err = rest.CallContext(ctx, uri, &body, &data); err != nil {
 		return err
 	}
-	if data.Error.String() != "" {
-		return fmt.Errorf(data.Error.String())
-	}
-	if len(data.Result) > 1 {
-		return fmt.Errorf("remove %s returned more than one result", path.String())
+	if err = data.err(); err != nil {
+		return err
 	}
-
 	return nil
 }
 
 // RemoveRec removes a key and its subtree recursively
 func (rest *Conn) RemoveRec(t Task, path Path) error {
+	return rest.RemoveRecContext(context.Background(), t, path)
+}
+
+// RemoveRecContext is like RemoveRec, but the request is aborted if ctx is cancelled.
+func (rest *Conn) RemoveRecContext(ctx context.Context, t Task, path Path) error {
 	var data removeReply
 	uri, err := rest.MakeCallURL("remove-rec", path, true)
 	if err != nil {
 		return err
 	}
 	body := postRequest{t, nil}
-	if err = rest.Call(uri, &body, &data); err != nil {
+	if err = rest.CallContext(ctx, uri, &body, &data); err != nil {
 		return err
 	}
-	if data.Error.String() != "" {
-		return fmt.Errorf(data.Error.String())
-		return fmt.Errorf("remove-rec %s returned empty result", path.String())
+	if err = data.err(); err != nil {
+		return err
 	}
 
 	return nil
 }
 
-// Iter iterates through all keys in database. Returns results in a channel as they are received.
-func (rest *Conn) Iter() (<-chan *Path, error) {
+// Iter iterates through all keys in database. Returns results in a channel as they are received. On error, the last item in
+// the channel will have .Error set - the channel is then closed.
+func (rest *Conn) Iter() (<-chan *IterResult, error) {
+	return rest.IterContext(context.Background())
+}
+
+// IterContext is like Iter, but the stream is stopped and the channel closed when ctx is cancelled.
+func (rest *Conn) IterContext(ctx context.Context) (<-chan *IterResult, error) {
 	uri, err := rest.MakeCallURL("iter", Path{}, true)
 	if err != nil {
 		return nil, err
 	}
 	var ch <-chan *StreamReply
-	if ch, err = rest.CallStream(uri, nil); err != nil || ch == nil {
+	if ch, err = rest.CallStreamContext(ctx, uri, nil); err != nil || ch == nil {
 		return nil, err
 	}
 
-	out := make(chan *Path, 1)
+	return iterPaths(ctx, ch), nil
+}
+
+// iterPaths unmarshals the paths in an iter stream
+func iterPaths(ctx context.Context, ch <-chan *StreamReply) <-chan *IterResult {
+	out := make(chan *IterResult, 1)
+
+	send := func(r *IterResult) bool {
+		select {
+		case out <- r:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
 
 	go func() {
 		defer close(out)
 		for m := range ch {
-			p := new(Path)
-			if err := json.Unmarshal(m.Result, &p); err != nil {
-				panic(err) // TODO This should be returned to caller
+			r := new(IterResult)
+			if err := m.err(); err != nil {
+				r.Error = err
+				send(r)
+				return
+			}
+			if err := json.Unmarshal(m.Result, &r.Path); err != nil {
+				r.Error = &ProtocolError{"unable to parse path", err}
+				send(r)
+				return
+			}
+			if !send(r) {
+				return
 			}
-			out <- p
 		}
 	}()
 
-	return out, err
+	return out
 }
 
-// Watch a specific key for create/delete/update. Returns commit/value pairs. This function is not recursive (see WatchPath)
-func (rest *Conn) Watch(path Path, firstCommit []byte) (<-chan *CommitValuePair, error) { // TODO not path
-	type watchKeyReply [][]Value // An array of arrays of commit/value pairs
+// Watch a specific key for create/delete/update. Returns commit/value pairs. This function is not recursive (see WatchPath).
+// On error, the last item in the channel will have .Error set - the channel is then closed.
+func (rest *Conn) Watch(path Path, firstCommit []byte) (<-chan *CommitValuePair, error) {
+	return rest.WatchContext(context.Background(), path, firstCommit)
+}
 
+// WatchContext is like Watch, but the stream is stopped and the channel closed when ctx is cancelled.
+func (rest *Conn) WatchContext(ctx context.Context, path Path, firstCommit []byte) (<-chan *CommitValuePair, error) { // TODO not path
 	var body *postRequest
 	if firstCommit != nil {
 		body = new(postRequest)
@@ -436,42 +539,62 @@ func (rest *Conn) Watch(path Path, firstCommit []byte) (<-chan *CommitValuePair,
 	}
 
 	var ch <-chan *StreamReply
-	if ch, err = rest.CallStream(uri, body); err != nil || ch == nil {
+	if ch, err = rest.CallStreamContext(ctx, uri, body); err != nil || ch == nil {
 		return nil, err
 	}
 
 	out := make(chan *CommitValuePair, 1)
 
+	send := func(c *CommitValuePair) bool {
+		select {
+		case out <- c:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
 	go func() {
 		defer close(out)
 		for m := range ch {
-			p := new([][]Value)
-			if err := json.Unmarshal(m.Result, p); err != nil {
-				panic(err) // TODO This should be returned to caller
+			if err := m.err(); err != nil {
+				send(&CommitValuePair{Error: err})
+				return
+			}
+			var p watchKeyReply
+			if err := json.Unmarshal(m.Result, &p); err != nil {
+				send(&CommitValuePair{Error: &ProtocolError{"unable to parse watch result", err}})
+				return
 			}
-			for _, q := range *p {
+			for _, q := range p {
 				if len(q) != 2 {
-					rest.log.Printf("length of response longer than 2 (%d), ignored", len(q))
+					rest.logger.Log(LevelWarn, "irmin watch reply has more than 2 elements, ignoring", Field{"length", len(q)})
 					continue
 				}
-				c := new(CommitValuePair)
-				c.Commit, err = hex.DecodeString(q[0].String())
+				commit, err := hex.DecodeString(q[0].String())
 				if err != nil {
-					rest.log.Printf("Unable to decode commit hash from watch (ignored): %s", q[0].String())
+					rest.logger.Log(LevelWarn, "irmin watch reply has invalid commit hash, ignoring", Field{"commit", q[0].String()})
 					continue
 				}
-				c.Value = q[1]
-				out <- c
+				if !send(&CommitValuePair{Commit: commit, Value: q[1]}) {
+					return
+				}
 			}
 		}
 	}()
 
-	return out, err
+	return out, nil
 }
 
 // WatchPath watches a path recursively. Returns keys that are updated, deleted or created. On error, the last item in the channel
-// will have .Error set - the channel is then closed.
-func (rest *Conn) WatchPath(path Path, firstCommit []byte) (<-chan *WatchPathCommit, error) { // TODO not path
+// will have .Error set - the channel is then closed. If firstCommit is set, changes since that commit are returned first.
+// See WatchPathResilient for a watch that is restarted automatically.
+func (rest *Conn) WatchPath(path Path, firstCommit []byte) (<-chan *WatchPathCommit, error) {
+	return rest.WatchPathContext(context.Background(), path, firstCommit)
+}
+
+// WatchPathContext is like WatchPath, but the stream is stopped and the channel closed when ctx is cancelled.
+func (rest *Conn) WatchPathContext(ctx context.Context, path Path, firstCommit []byte) (<-chan *WatchPathCommit, error) { // TODO not path
 	uri, err := rest.MakeCallURL("watch-rec", path, true)
 	if err != nil {
 		return nil, err
@@ -482,52 +605,58 @@ func (rest *Conn) WatchPath(path Path, firstCommit []byte) (<-chan *WatchPathCom
 		body = new(postRequest)
 		body.Task = rest.NewTask("Watching db")
 		s := hex.EncodeToString(firstCommit)
-		body.Data = json.RawMessage(fmt.Sprintf("[\"%s\"]", s, s))
+		body.Data = json.RawMessage(fmt.Sprintf("[\"%s\"]", s))
 	}
 
 	var ch <-chan *StreamReply
-	if ch, err = rest.CallStream(uri, nil); err != nil || ch == nil {
+	if ch, err = rest.CallStreamContext(ctx, uri, body); err != nil || ch == nil {
 		return nil, err
 	}
 
 	out := make(chan *WatchPathCommit, 1)
 
-	type change struct {
-		Change string `json:""`
-		Key    Path   `json:""`
+	send := func(c *WatchPathCommit) bool {
+		select {
+		case out <- c:
+			return true
+		case <-ctx.Done():
+			return false
+		}
 	}
 
 	go func() {
 		defer close(out)
 		for m := range ch {
 			c := new(WatchPathCommit)
+			if err := m.err(); err != nil {
+				c.Error = err
+				send(c)
+				return
+			}
 
 			var q [2]json.RawMessage // array of raw messages
 			if err := json.Unmarshal(m.Result, &q); err != nil {
-				fmt.Printf("json(0): %s\n", m.Result)
-				c.Error = err
-				out <- c
+				c.Error = &ProtocolError{"unable to parse watch-rec result", err}
+				send(c)
 				return
 			}
 
 			var s string // first entry in array is string (commit hash)
 			if err := json.Unmarshal(q[0], &s); err != nil {
-				fmt.Printf("json(1): %s\n", q[0])
-				c.Error = err
-				out <- c
+				c.Error = &ProtocolError{"unable to parse watch-rec result", err}
+				send(c)
 				return
 			}
 			commit, err := hex.DecodeString(s)
 			if err != nil {
-				rest.log.Printf("Unable to decode commit hash from watch-rec (ignored): %s", s)
+				rest.logger.Log(LevelWarn, "irmin watch-rec reply has invalid commit hash, ignoring", Field{"commit", s})
 				continue
 			}
 
 			var changes []json.RawMessage // second entry is array of string/path pairs
 			if err := json.Unmarshal(q[1], &changes); err != nil {
-				fmt.Printf("json(2): %s\n", q[1])
-				c.Error = err
-				out <- c
+				c.Error = &ProtocolError{"unable to parse watch-rec result", err}
+				send(c)
 				return
 			}
 
@@ -537,30 +666,27 @@ func (rest *Conn) WatchPath(path Path, firstCommit []byte) (<-chan *WatchPathCom
 			for x, pair := range changes {
 				var k []json.RawMessage // split pair in hash + path
 				if err := json.Unmarshal(pair, &k); err != nil {
-					fmt.Printf("json(3): %s\n", pair)
-					c.Error = err
-					out <- c
+					c.Error = &ProtocolError{"unable to parse watch-rec result", err}
+					send(c)
 					return
 				}
 				if len(k) != 2 {
-					c.Error = fmt.Errorf("Expected string/path pair array of len 2, actual len was %d", len(k))
-					out <- c
+					c.Error = &ProtocolError{Msg: fmt.Sprintf("expected string/path pair array of len 2, actual len was %d", len(k))}
+					send(c)
 					return
 				}
 
 				var changetype string
 				if err := json.Unmarshal(k[0], &changetype); err != nil {
-					fmt.Printf("json(4): %s\n", k[0])
-					c.Error = err
-					out <- c
+					c.Error = &ProtocolError{"unable to parse watch-rec result", err}
+					send(c)
 					return
 				}
 
 				var key Path
 				if err := json.Unmarshal(k[1], &key); err != nil {
-					fmt.Printf("json(5): %s\n", k[1])
-					c.Error = err
-					out <- c
+					c.Error = &ProtocolError{"unable to parse watch-rec result", err}
+					send(c)
 					return
 				}
 
@@ -568,15 +694,22 @@ func (rest *Conn) WatchPath(path Path, firstCommit []byte) (<-chan *WatchPathCom
 				c.Changes[x].Key = key
 			}
 
-			out <- c
+			if !send(c) {
+				return
+			}
 		}
 	}()
 
-	return out, err
+	return out, nil
 }
 
 // Clone the current tree and create a named tag. Force overwrites a previous clone with the same name.
 func (rest *Conn) Clone(t Task, name string, force bool) error {
+	return rest.CloneContext(context.Background(), t, name, force)
+}
+
+// CloneContext is like Clone, but the request is aborted if ctx is cancelled.
+func (rest *Conn) CloneContext(ctx context.Context, t Task, name string, force bool) error {
 	var data cloneReply
 
 	path, err := ParseEncodedPath(url.QueryEscape(name)) // encode and wrap in IrminPath
@@ -594,25 +727,27 @@ func (rest *Conn) Clone(t Task, name string, force bool) error {
 	}
 
 	body := postRequest{t, nil}
-	if err = rest.Call(uri, &body, &data); err != nil {
+	if err = rest.CallContext(ctx, uri, &body, &data); err != nil {
 		return err
 	}
-	if data.Error.String() != "" {
-		return fmt.Errorf(data.Error.String())
-	}
-	if len(data.Result) > 1 {
-		return fmt.Errorf("%s %s returned more than one result", command, name)
+	if err = data.err(); err != nil {
+		return err
 	}
 	if (data.Result.String() != "ok") || (data.Result.String() == "" && force) {
-		return fmt.Errorf(data.Result.String())
+		return &ServerError{Version: data.Version.String(), Message: data.Result.String()}
 	}
 
 	return nil
 }
 
-// CompareAndSet sets a key if the current value is equal to the given value.
+// CompareAndSet sets a key if the current value is equal to the given value. If the value differs a *ConflictError is returned.
 func (rest *Conn) CompareAndSet(t Task, path Path, oldcontents *[]byte, contents *[]byte) (string, error) {
-	var data updateReply
+	return rest.CompareAndSetContext(context.Background(), t, path, oldcontents, contents)
+}
+
+// CompareAndSetContext is like CompareAndSet, but the request is aborted if ctx is cancelled.
+func (rest *Conn) CompareAndSetContext(ctx context.Context, t Task, path Path, oldcontents *[]byte, contents *[]byte) (string, error) {
+	var data casReply
 
 	uri, err := rest.MakeCallURL("compare-and-set", path, true)
 	if err != nil {
@@ -630,15 +765,21 @@ func (rest *Conn) CompareAndSet(t Task, path Path, oldcontents *[]byte, contents
 
 	body.Task = t
 
-	if err = rest.Call(uri, &body, &data); err != nil {
-		return data.Result.String(), err
+	if err = rest.CallContext(ctx, uri, &body, &data); err != nil {
+		return "", err
 	}
-	if data.Error.String() != "" {
-		return "", fmt.Errorf(data.Error.String())
+	if err = data.err(); err != nil {
+		return "", err
 	}
-	if data.Result.String() == "" {
-		return "", fmt.Errorf("compare-and-set seemed to succeed, but didn't return a hash", path.String(), data.Result.String())
+	if len(data.Result) > 0 && data.Result[0] == '{' { // merge result, check for conflict
+		if err = mergeResult(data.Result); err != nil {
+			return "", err
+		}
+	}
+	var hash Value
+	if err = json.Unmarshal(data.Result, &hash); err != nil || hash.String() == "" {
+		return "", &ProtocolError{fmt.Sprintf("compare-and-set %s seemed to succeed, but didn't return a hash", path.String()), err}
 	}
 
-	return data.Result.String(), nil
+	return hash.String(), nil
 }
@@ -15,107 +15,70 @@
  OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
 */
 
-package irmin
+package irmin_test
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/hex"
-	"errors"
 	"fmt"
-	"net/url"
-	"os/exec"
-	"strings"
 	"testing"
 	"time"
-)
 
-func irminExec(t *testing.T, cmd string, args ...string) error {
-	fullArgs := []string{cmd, "-s", "http", "--uri", "http://127.0.0.1:8085"}
-	fullArgs = append(fullArgs, args...)
-	t.Log("Running irmin %s", fullArgs)
-	return exec.Command("irmin", fullArgs...).Run()
-}
+	"github.com/MagnusS/irmin-go/irmin"
+	"github.com/MagnusS/irmin-go/irmin/irmintest"
+)
 
-func spawnIrmin(t *testing.T) *exec.Cmd {
-	t.Log("Starting Irmin")
-	c := exec.Command("irmin", "init", "-d", "-v", "-s", "mem", "-a", "http://127.0.0.1:8085")
-	//c := exec.Command("irmin", "init", "-d", "-v", "--root", "irmin_test", "-a", "http://:8085")
-	stdout, err := c.StdoutPipe()
-	if err != nil {
-		t.Fatal(err)
-	}
-	err = c.Start()
-	if err != nil {
-		t.Fatal(err)
-	}
-	fromIrmin := bufio.NewReader(stdout)
-	if fromIrmin == nil {
-		t.Fatal(errors.New("NewReader returned nil!"))
-	}
-	for {
-		line, err := fromIrmin.ReadString('\n')
-		if err != nil {
-			t.Fatal(err)
-		}
-		// TODO: we really want to know when it is "started", not "starting"
-		if strings.HasPrefix(line, "Server starting on port") {
-			break
-		}
-		t.Log("Unexpected Irmin output: %#v", line)
-	}
-	return c
+func spawnIrmin(t *testing.T) *irmintest.Server {
+	t.Log("Starting in-memory Irmin")
+	return irmintest.NewServer()
 }
 
-func stopIrmin(t *testing.T, c *exec.Cmd) {
-	t.Log("Stopping irmin")
-	err := c.Process.Kill()
-	if err != nil {
-		t.Fatal(err)
-	}
-	err = c.Wait()
-	if err != nil {
-		t.Log(err)
-	}
+func stopIrmin(t *testing.T, s *irmintest.Server) {
+	t.Log("Stopping in-memory Irmin")
+	s.Close()
 }
 
 func TestIrminConnect(t *testing.T) {
 	// Start Irmin
-	irmin := spawnIrmin(t)
+	srv := spawnIrmin(t)
 	// Stop Irmin
-	defer stopIrmin(t, irmin)
-}
+	defer stopIrmin(t, srv)
 
-func getConn(t *testing.T) *Conn {
-	uri, err := url.Parse("http://127.0.0.1:8085")
-	t.Log("Connecting to irmin @ ", uri)
+	v, err := srv.Conn("irmin-go-tester").Version()
 	if err != nil {
 		t.Fatal(err)
 	}
-	return Create(uri, "irmin-go-tester")
+	if v != irmintest.Version {
+		t.Fatalf("expected version %s, got %s", irmintest.Version, v)
+	}
+}
+
+func getConn(t *testing.T, s *irmintest.Server) *irmin.Conn {
+	t.Log("Connecting to irmin @ ", s.URL)
+	return s.Conn("irmin-go-tester")
 }
 
 func TestHead(t *testing.T) {
-	irmin := spawnIrmin(t)
-	defer stopIrmin(t, irmin)
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
 
 	t.Log("Testing Head on empty db")
 
-	r := getConn(t)
+	r := getConn(t, srv)
 
 	v, err := r.Head()
 	if err != nil {
 		t.Fatal(err)
 	}
 	if v != nil {
-		t.Fatal("head should be nil, but was %s\n", hex.EncodeToString(v))
+		t.Fatalf("head should be nil, but was %s\n", hex.EncodeToString(v))
 	}
 
 	t.Log("Testing Head on non-empty db")
 
 	key := "head-test"
 	data := []byte("foo")
-	hash, err := r.Update(r.NewTask("update key"), ParsePath(key), data)
+	hash, err := r.Update(r.NewTask("update key"), irmin.ParsePath(key), data)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -129,43 +92,43 @@ func TestHead(t *testing.T) {
 	t.Logf("head is: %s\n", h)
 
 	if h != hash {
-		t.Fatal("Hash returned by update did not match head (%s vs %s)", h, hash)
+		t.Fatalf("Hash returned by update did not match head (%s vs %s)", h, hash)
 	}
 
 }
 
 func TestUpdate(t *testing.T) {
-	irmin := spawnIrmin(t)
-	defer stopIrmin(t, irmin)
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
 
-	r := getConn(t)
+	r := getConn(t, srv)
 	key := "update-test"
 	t.Logf("update key '%s'", key)
 	data := []byte("Hello \"world")
-	hash, err := r.Update(r.NewTask("update key"), ParsePath(key), data)
+	hash, err := r.Update(r.NewTask("update key"), irmin.ParsePath(key), data)
 	if err != nil {
 		t.Fatal(err)
 	}
 	t.Logf("update hash: %s\n", hash)
 	t.Logf("read key `%s`", key)
-	d, err := r.ReadString(ParsePath(key))
+	d, err := r.ReadString(irmin.ParsePath(key))
 	if err != nil {
 		t.Fatal(err)
 	}
 	if bytes.Compare(data, []byte(d)) != 0 {
-		t.Fatal("update/read failed. Written value '%s' != '%s'", string(data), d)
+		t.Fatalf("update/read failed. Written value '%s' != '%s'", string(data), d)
 	}
 }
 
 func TestWatch(t *testing.T) {
-	irmin := spawnIrmin(t)
-	defer stopIrmin(t, irmin)
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
 
 	// Connect to Irmin
-	r := getConn(t)
+	r := getConn(t, srv)
 
 	// expect function waits for expected result with a timeout
-	expect := func(ch <-chan *CommitValuePair, path Path, val []byte) {
+	expect := func(ch <-chan *irmin.CommitValuePair, path irmin.Path, val []byte) {
 		// Wait for Watch result or timeout
 		timeout := time.After(1 * time.Second)
 		select {
@@ -188,7 +151,7 @@ func TestWatch(t *testing.T) {
 
 	// Test watching an existing key
 	{
-		path := ParsePath("/watch-test/1")
+		path := irmin.ParsePath("/watch-test/1")
 		data := []byte("foo")
 		t.Logf("update key '%s'='%s'", path.String(), string(data))
 		hash, err := r.Update(r.NewTask("update key"), path, data)
@@ -216,7 +179,7 @@ func TestWatch(t *testing.T) {
 
 	// Test watching a non-existing key
 	{
-		path := ParsePath("/watch-test/2")
+		path := irmin.ParsePath("/watch-test/2")
 		t.Logf("Set Watch on non-existing key %s", path.String())
 		ch, err := r.Watch(path, nil)
 		if err != nil {
@@ -236,7 +199,7 @@ func TestWatch(t *testing.T) {
 
 	// Test multiple updates
 	{
-		path := ParsePath("/watch-test/3")
+		path := irmin.ParsePath("/watch-test/3")
 		t.Logf("Set Watch for multiple updates on key %s", path.String())
 		ch, err := r.Watch(path, nil)
 		if err != nil {
@@ -268,7 +231,7 @@ func TestWatch(t *testing.T) {
 
 	// Test multiple updates, no delay
 	{
-		path := ParsePath("/watch-test/4")
+		path := irmin.ParsePath("/watch-test/4")
 		t.Logf("Set Watch for multiple updates on key %s (no delay)", path.String())
 		ch, err := r.Watch(path, nil)
 		if err != nil {
@@ -297,3 +260,116 @@ func TestWatch(t *testing.T) {
 		t.Log("done")
 	}
 }
+
+func TestListMemRemove(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	r := getConn(t, srv)
+	for _, k := range []string{"/a/b/c", "/a/b/d", "/a/e"} {
+		if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath(k), []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths, err := r.List(irmin.ParsePath("/a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 || paths[0].String() != "/a/b" || paths[1].String() != "/a/e" {
+		t.Fatalf("unexpected list result %v", paths)
+	}
+
+	if err := r.Remove(r.NewTask("remove key"), irmin.ParsePath("/a/e")); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := r.Mem(irmin.ParsePath("/a/e")); err != nil || b {
+		t.Fatalf("/a/e should have been removed (mem=%t, err=%v)", b, err)
+	}
+
+	if err := r.RemoveRec(r.NewTask("remove subtree"), irmin.ParsePath("/a/b")); err != nil {
+		t.Fatal(err)
+	}
+	ch, err := r.Iter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p := range ch {
+		t.Fatalf("expected empty db, found %s (err=%v)", p.Path.String(), p.Error)
+	}
+}
+
+func TestCloneFromTree(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	r := getConn(t, srv)
+	path := irmin.ParsePath("/clone-test")
+	if _, err := r.Update(r.NewTask("update key"), path, []byte("master")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Clone(r.NewTask("clone"), "dev", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Clone(r.NewTask("clone"), "dev", false); err == nil {
+		t.Fatal("Clone to an existing branch should fail without force")
+	}
+
+	dev := r.FromTree("dev")
+	if _, err := dev.Update(dev.NewTask("update key"), path, []byte("dev")); err != nil {
+		t.Fatal(err)
+	}
+	for conn, expected := range map[*irmin.Conn]string{r: "master", dev: "dev"} {
+		v, err := conn.ReadString(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != expected {
+			t.Fatalf("expected %s in tree %s, got %s", expected, conn.Tree(), v)
+		}
+	}
+}
+
+func TestWatchPath(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	r := getConn(t, srv)
+	if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/watch-path/a"), []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	ch, err := r.WatchPath(irmin.ParsePath("/watch-path"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(change string, key string) {
+		select {
+		case c := <-ch:
+			if c.Error != nil {
+				t.Fatal(c.Error)
+			}
+			if len(c.Changes) != 1 || c.Changes[0].Change != change || c.Changes[0].Key.String() != key {
+				t.Fatalf("expected %s %s, got %v", change, key, c.Changes)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatal("Timed out while waiting for WatchPath result")
+		}
+	}
+
+	if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/not-watched"), []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/watch-path/a"), []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	expect(irmin.KeyUpdated, "/watch-path/a")
+	if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/watch-path/b"), []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	expect(irmin.KeyCreated, "/watch-path/b")
+	if err := r.Remove(r.NewTask("remove key"), irmin.ParsePath("/watch-path/a")); err != nil {
+		t.Fatal(err)
+	}
+	expect(irmin.KeyDeleted, "/watch-path/a")
+}
@@ -0,0 +1,157 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"time"
+)
+
+// Instrumentation receives measurements for every call and stream made by a Client, see WithInstrumentation.
+// Methods are called synchronously from the calling goroutine or the stream reader, so they must be fast and safe for
+// concurrent use.
+type Instrumentation interface {
+	RequestStarted(command string) // A call or the start of a stream is about to be sent
+	RequestFinished(r RequestInfo) // A call has completed, or a stream has been opened or failed to open
+	StreamOpened(command string)   // A stream has been opened and items will follow
+	StreamItem(command string)     // An item has been received from a stream
+	StreamClosed(s StreamInfo)     // A stream opened with StreamOpened has been closed
+}
+
+// RequestInfo describes a finished request. The command is the Irmin command in the URL created by MakeCallURL, without
+// the tree prefix, path or view node, e.g. "read" or "view/update".
+type RequestInfo struct {
+	Command       string
+	Stream        bool          // The request started a stream
+	Status        int           // HTTP status of the last attempt, 0 if no reply was received
+	Attempts      int           // Number of attempts, more than 1 if the request was retried
+	BytesSent     int64         // Size of the request bodies of all attempts
+	BytesReceived int64         // Size of the reply body. Not set for streams, see StreamInfo.
+	Duration      time.Duration // Time until the reply was read, or the stream was opened
+	Err           error         // Transport, HTTP status or parse error. Errors returned by Irmin in a reply are not included.
+
+	start   time.Time
+	tree    string // Tree and path in the URL, for log messages
+	path    Path
+	request []byte // Request body, nil for GET
+}
+
+// StreamInfo describes a closed stream
+type StreamInfo struct {
+	Command       string
+	Items         int64         // Number of items received
+	BytesReceived int64         // Size of the stream, including the stream start
+	Duration      time.Duration // Time the stream was open
+	Err           error         // Set if the stream failed or was cancelled
+}
+
+// IgnoreInstrumentation is an implementation of Instrumentation that ignores all measurements
+type IgnoreInstrumentation struct{}
+
+// RequestStarted is ignored
+func (IgnoreInstrumentation) RequestStarted(command string) {}
+
+// RequestFinished is ignored
+func (IgnoreInstrumentation) RequestFinished(r RequestInfo) {}
+
+// StreamOpened is ignored
+func (IgnoreInstrumentation) StreamOpened(command string) {}
+
+// StreamItem is ignored
+func (IgnoreInstrumentation) StreamItem(command string) {}
+
+// StreamClosed is ignored
+func (IgnoreInstrumentation) StreamClosed(s StreamInfo) {}
+
+// startRequest reports the start of a request to uri and returns the RequestInfo to pass to finishRequest
+func (c *Client) startRequest(uri *url.URL, stream bool) *RequestInfo {
+	r := &RequestInfo{Stream: stream, start: time.Now()}
+	r.tree, r.Command, r.path = splitCallURL(uri)
+	c.instrumentation.RequestStarted(r.Command)
+	return r
+}
+
+// finishRequest reports and logs a finished request. reply is the reply body, nil for streams.
+func (c *Client) finishRequest(r *RequestInfo, reply []byte, err error) {
+	r.Duration = time.Since(r.start)
+	r.Err = err
+	c.instrumentation.RequestFinished(*r)
+
+	level, msg := LevelDebug, "irmin call"
+	if r.Stream {
+		msg = "irmin stream opened"
+	}
+	if err != nil {
+		level = LevelWarn
+	}
+	fields := []Field{{"command", r.Command}, {"path", pathString(r.path)}}
+	if r.tree != "" {
+		fields = append(fields, Field{"tree", r.tree})
+	}
+	fields = append(fields, Field{"status", r.Status}, Field{"duration", r.Duration})
+	if r.Attempts > 1 {
+		fields = append(fields, Field{"attempts", r.Attempts})
+	}
+	fields = append(fields, Field{"bytes_sent", r.BytesSent}, Field{"bytes_received", r.BytesReceived})
+	hide := c.redaction.hide(r.Command, r.path)
+	if r.request != nil {
+		fields = append(fields, Field{"request", c.redact(hide, r.request)})
+	}
+	if reply != nil {
+		fields = append(fields, Field{"reply", c.redact(hide, reply)})
+	}
+	if err != nil {
+		fields = append(fields, Field{"error", err})
+	}
+	c.logger.Log(level, msg, fields...)
+}
+
+// redact returns body as a string, or a placeholder if hide is set
+func (c *Client) redact(hide bool, body []byte) string {
+	if hide {
+		return redactedValue
+	}
+	return string(body)
+}
+
+// finishStream reports and logs a closed stream
+func (c *Client) finishStream(ctx context.Context, s StreamInfo) {
+	c.instrumentation.StreamClosed(s)
+	level := LevelDebug
+	if s.Err != nil && ctx.Err() == nil { // cancelling a stream is not a failure
+		level = LevelWarn
+	}
+	fields := []Field{{"command", s.Command}, {"items", s.Items}, {"bytes_received", s.BytesReceived}, {"duration", s.Duration}}
+	if s.Err != nil {
+		fields = append(fields, Field{"error", s.Err})
+	}
+	c.logger.Log(level, "irmin stream closed", fields...)
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
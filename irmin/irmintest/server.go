@@ -0,0 +1,893 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+// Package irmintest provides an in-memory implementation of the Irmin REST API for use in tests.
+//
+// The server implements the subset of the protocol used by the irmin package, including the tree/ and view/
+// command prefixes and streamed replies for iter, watch and watch-rec.
+package irmintest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/MagnusS/irmin-go/irmin"
+)
+
+// Version is the Irmin version reported by the server
+const Version = "0.10.0"
+
+// Server is an in-memory Irmin HTTP server listening on a local port
+type Server struct {
+	URL string // Base URL of the form http://ipaddr:port with no trailing slash
+
+	srv      *httptest.Server
+	mu       sync.Mutex
+	n        int                // counter used to create unique commit and view ids
+	branches map[string]string  // branch name -> commit hash
+	commits  map[string]*commit // commit hash -> commit
+	views    map[string]*view   // view node -> view
+	watchers map[*watcher]bool  // active watch streams
+	closed   chan struct{}      // closed when the server is shutting down
+}
+
+// request contains a parsed Irmin command
+type request struct {
+	tree    string // branch name or commit hash, defaults to master
+	node    string // view node if this is a view command
+	command string
+	path    []string
+	task    irmin.Task
+	params  json.RawMessage
+}
+
+type commandFunc func(s *Server, req *request) (interface{}, error)
+type streamFunc func(s *Server, req *request, w *streamWriter, r *http.Request)
+
+var commands = map[string]commandFunc{
+	"list":            (*Server).list,
+	"mem":             (*Server).mem,
+	"head":            (*Server).head,
+	"read":            (*Server).read,
+	"update":          (*Server).update,
+	"remove":          (*Server).remove,
+	"remove-rec":      (*Server).removeRec,
+	"clone":           (*Server).clone,
+	"clone-force":     (*Server).clone,
+	"compare-and-set": (*Server).compareAndSet,
+	"view/create":     (*Server).viewCreate,
+
+	"branches":             (*Server).listBranches,
+	"remove-branch":        (*Server).removeBranch,
+	"update-head":          (*Server).updateHead,
+	"fast-forward-head":    (*Server).fastForwardHead,
+	"compare-and-set-head": (*Server).compareAndSetHead,
+	"commit":               (*Server).readCommit,
+	"merge":                (*Server).mergeTree,
+	"merge-head":           (*Server).mergeTree,
+	"lca":                  (*Server).lca,
+}
+
+func init() {
+	commands[""] = (*Server).commands // added here as the command list refers to commands
+}
+
+var streams = map[string]streamFunc{
+	"iter":      (*Server).iter,
+	"watch":     (*Server).watch,
+	"watch-rec": (*Server).watchRec,
+}
+
+var viewCommands = map[string]commandFunc{
+	"read":        (*Server).viewRead,
+	"update":      (*Server).viewUpdate,
+	"merge-path":  (*Server).viewMergePath,
+	"update-path": (*Server).viewUpdatePath,
+	"remove":      (*Server).viewRemove,
+	"remove-rec":  (*Server).viewRemoveRec,
+	"list":        (*Server).viewList,
+	"mem":         (*Server).viewMem,
+}
+
+var viewStreams = map[string]streamFunc{
+	"iter": (*Server).viewIter,
+}
+
+// NewServer starts and returns a new server with an empty store. The caller should call Close when finished.
+func NewServer() *Server {
+	s := &Server{
+		branches: make(map[string]string),
+		commits:  make(map[string]*commit),
+		views:    make(map[string]*view),
+		watchers: make(map[*watcher]bool),
+		closed:   make(chan struct{}),
+	}
+	s.srv = httptest.NewServer(s)
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close stops all active streams and shuts down the server
+func (s *Server) Close() {
+	close(s.closed)
+	s.srv.Close()
+}
+
+// CloseClientConnections closes all open client connections, including active streams. The server keeps running, so
+// this can be used to test how clients handle network failures.
+func (s *Server) CloseClientConnections() {
+	s.srv.CloseClientConnections()
+}
+
+// Conn returns a connection to the server
+func (s *Server) Conn(taskowner string, opts ...irmin.ClientOption) *irmin.Conn {
+	uri, err := url.Parse(s.URL)
+	if err != nil {
+		panic(err) // URL is created by httptest and always valid
+	}
+	return irmin.Create(uri, taskowner, opts...)
+}
+
+// ServeHTTP parses and dispatches an Irmin command
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req, err := parseRequest(r)
+	if err != nil {
+		writeReply(w, http.StatusBadRequest, nil, err)
+		return
+	}
+
+	cmds, strs := commands, streams
+	if req.node != "" {
+		cmds, strs = viewCommands, viewStreams
+	}
+	if f, ok := cmds[req.command]; ok {
+		s.mu.Lock()
+		res, err := f(s, req)
+		s.mu.Unlock()
+		writeReply(w, http.StatusOK, res, err)
+		return
+	}
+	if f, ok := strs[req.command]; ok {
+		f(s, req, newStreamWriter(w), r)
+		return
+	}
+	writeReply(w, http.StatusNotFound, nil, fmt.Errorf("unknown command %s", req.command))
+}
+
+// parseRequest splits the URL in tree, view node, command and path and decodes the body of POST requests
+func parseRequest(r *http.Request) (*request, error) {
+	var segs []string
+	for _, s := range strings.Split(r.URL.EscapedPath(), "/") {
+		u, err := url.PathUnescape(s)
+		if err != nil {
+			return nil, err
+		}
+		segs = append(segs, u)
+	}
+	if len(segs) > 0 && segs[0] == "" { // leading /
+		segs = segs[1:]
+	}
+
+	req := &request{tree: "master"}
+	if len(segs) >= 2 && segs[0] == "tree" {
+		if segs[1] != "" {
+			req.tree = segs[1]
+		}
+		segs = segs[2:]
+	}
+	if len(segs) >= 3 && segs[0] == "view" && segs[1] == "create" && segs[2] == "create" {
+		req.command = "view/create"
+		segs = segs[3:]
+	} else if len(segs) >= 3 && segs[0] == "view" {
+		req.node = segs[1]
+		segs = segs[2:]
+	}
+	if req.command == "" && len(segs) > 0 {
+		req.command = segs[0]
+		segs = segs[1:]
+	}
+	for _, s := range segs {
+		if s != "" {
+			req.path = append(req.path, s)
+		}
+	}
+
+	if r.Method == "POST" {
+		var body struct {
+			Task   irmin.Task      `json:"task"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return nil, err
+		}
+		req.task = body.Task
+		req.params = body.Params
+	}
+	return req, nil
+}
+
+// writeReply writes a result or an error in the Irmin reply format
+func writeReply(w http.ResponseWriter, status int, result interface{}, err error) {
+	reply := map[string]interface{}{"version": Version}
+	if err != nil {
+		reply["error"] = err.Error()
+	} else {
+		reply["result"] = result
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(reply)
+}
+
+// value returns v as a pointer, so it is encoded with irmin.Value.MarshalJSON
+func value(v []byte) *irmin.Value {
+	i := irmin.Value(v)
+	return &i
+}
+
+// headOf returns the commit hash a tree points to. A tree is either a branch or a commit hash. Commit hashes
+// are detached and can not be updated.
+func (s *Server) headOf(name string) (hash string, detached bool) {
+	if h, ok := s.branches[name]; ok {
+		return h, false
+	}
+	if _, ok := s.commits[name]; ok {
+		return name, true
+	}
+	return "", false
+}
+
+// treeOf returns the contents at a commit. The empty hash returns an empty tree.
+func (s *Server) treeOf(hash string) tree {
+	if c, ok := s.commits[hash]; ok {
+		return c.tree
+	}
+	return tree{}
+}
+
+// commit stores t as a new commit in the request tree and moves the branch head
+func (s *Server) commit(req *request, t tree, parents ...string) (string, error) {
+	head, detached := s.headOf(req.tree)
+	if detached {
+		return "", fmt.Errorf("can not update detached head %s", req.tree)
+	}
+	if head != "" {
+		parents = append([]string{head}, parents...)
+	}
+	s.n++
+	c := newCommit(s.n, parents, req.task, t)
+	s.commits[c.hash] = c
+	s.setHead(req.tree, c.hash)
+	return c.hash, nil
+}
+
+// setHead moves a branch and notifies watchers
+func (s *Server) setHead(branch string, hash string) {
+	old := s.branches[branch]
+	s.branches[branch] = hash
+	for w := range s.watchers {
+		if w.branch == branch {
+			w.push(old, hash)
+		}
+	}
+}
+
+func (s *Server) commands(req *request) (interface{}, error) {
+	var names []string
+	for k := range commands {
+		names = append(names, k)
+	}
+	for k := range streams {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names[1:], nil // skip ""
+}
+
+func (s *Server) list(req *request) (interface{}, error) {
+	head, _ := s.headOf(req.tree)
+	return s.treeOf(head).children(req.path), nil
+}
+
+func (s *Server) mem(req *request) (interface{}, error) {
+	head, _ := s.headOf(req.tree)
+	_, ok := s.treeOf(head)[keyOf(req.path)]
+	return ok, nil
+}
+
+func (s *Server) head(req *request) (interface{}, error) {
+	head, _ := s.headOf(req.tree)
+	if head == "" {
+		return []string{}, nil
+	}
+	return []string{head}, nil
+}
+
+func (s *Server) read(req *request) (interface{}, error) {
+	head, _ := s.headOf(req.tree)
+	v, ok := s.treeOf(head)[keyOf(req.path)]
+	if !ok {
+		return []irmin.Value{}, nil
+	}
+	return []irmin.Value{v}, nil
+}
+
+func (s *Server) update(req *request) (interface{}, error) {
+	var v irmin.Value
+	if err := json.Unmarshal(req.params, &v); err != nil {
+		return nil, err
+	}
+	head, _ := s.headOf(req.tree)
+	t := s.treeOf(head).copy()
+	t[keyOf(req.path)] = v
+	return s.commit(req, t)
+}
+
+func (s *Server) remove(req *request) (interface{}, error) {
+	head, _ := s.headOf(req.tree)
+	t := s.treeOf(head).copy()
+	delete(t, keyOf(req.path))
+	_, err := s.commit(req, t)
+	return nil, err
+}
+
+func (s *Server) removeRec(req *request) (interface{}, error) {
+	head, _ := s.headOf(req.tree)
+	_, err := s.commit(req, s.treeOf(head).replace(req.path, nil))
+	return nil, err
+}
+
+func (s *Server) clone(req *request) (interface{}, error) {
+	if len(req.path) != 1 {
+		return nil, fmt.Errorf("invalid branch name")
+	}
+	name := req.path[0]
+	if _, ok := s.branches[name]; ok && req.command != "clone-force" {
+		return "duplicated-tag", nil
+	}
+	head, _ := s.headOf(req.tree)
+	s.setHead(name, head)
+	return "ok", nil
+}
+
+func (s *Server) compareAndSet(req *request) (interface{}, error) {
+	var params [][]*irmin.Value
+	if err := json.Unmarshal(req.params, &params); err != nil {
+		return nil, err
+	}
+	if len(params) != 2 {
+		return nil, fmt.Errorf("compare-and-set expects old and new value")
+	}
+	opt := func(v []*irmin.Value) *irmin.Value { // values are optional, represented as lists with 0 or 1 element
+		if len(v) == 0 {
+			return nil
+		}
+		return v[0]
+	}
+	test, set := opt(params[0]), opt(params[1])
+
+	head, _ := s.headOf(req.tree)
+	t := s.treeOf(head).copy()
+	k := keyOf(req.path)
+	cur, ok := t[k]
+	if ok != (test != nil) || (ok && string(cur) != test.String()) {
+		return map[string]interface{}{"conflict": "test-and-set failed"}, nil
+	}
+	if set == nil {
+		delete(t, k)
+	} else {
+		t[k] = *set
+	}
+	return s.commit(req, t)
+}
+
+func (s *Server) listBranches(req *request) (interface{}, error) {
+	names := []string{}
+	for name := range s.branches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *Server) removeBranch(req *request) (interface{}, error) {
+	if len(req.path) != 1 {
+		return nil, fmt.Errorf("invalid branch name")
+	}
+	delete(s.branches, req.path[0])
+	return nil, nil
+}
+
+// branchOf returns the head of the request tree, or an error if the tree is a commit hash
+func (s *Server) branchOf(req *request) (string, error) {
+	head, detached := s.headOf(req.tree)
+	if detached {
+		return "", fmt.Errorf("can not update detached head %s", req.tree)
+	}
+	return head, nil
+}
+
+// commitOf returns the commit hash in the request path
+func (s *Server) commitOf(req *request) (string, error) {
+	if len(req.path) != 1 {
+		return "", fmt.Errorf("invalid commit hash")
+	}
+	if _, ok := s.commits[req.path[0]]; !ok {
+		return "", fmt.Errorf("unknown commit %s", req.path[0])
+	}
+	return req.path[0], nil
+}
+
+func (s *Server) updateHead(req *request) (interface{}, error) {
+	if _, err := s.branchOf(req); err != nil {
+		return nil, err
+	}
+	hash, err := s.commitOf(req)
+	if err != nil {
+		return nil, err
+	}
+	s.setHead(req.tree, hash)
+	return nil, nil
+}
+
+func (s *Server) fastForwardHead(req *request) (interface{}, error) {
+	head, err := s.branchOf(req)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := s.commitOf(req)
+	if err != nil {
+		return nil, err
+	}
+	if head != "" && !s.isAncestor(head, hash) {
+		return false, nil
+	}
+	if head != hash {
+		s.setHead(req.tree, hash)
+	}
+	return true, nil
+}
+
+func (s *Server) compareAndSetHead(req *request) (interface{}, error) {
+	var params [][]string
+	if err := json.Unmarshal(req.params, &params); err != nil {
+		return nil, err
+	}
+	if len(params) != 2 {
+		return nil, fmt.Errorf("compare-and-set-head expects old and new commit")
+	}
+	opt := func(v []string) string { // hashes are optional, represented as lists with 0 or 1 element
+		if len(v) == 0 {
+			return ""
+		}
+		return v[0]
+	}
+	test, set := opt(params[0]), opt(params[1])
+
+	head, err := s.branchOf(req)
+	if err != nil {
+		return nil, err
+	}
+	if head != test {
+		return false, nil
+	}
+	if set == "" {
+		delete(s.branches, req.tree)
+		return true, nil
+	}
+	if _, ok := s.commits[set]; !ok {
+		return nil, fmt.Errorf("unknown commit %s", set)
+	}
+	s.setHead(req.tree, set)
+	return true, nil
+}
+
+func (s *Server) readCommit(req *request) (interface{}, error) {
+	if len(req.path) != 1 {
+		return nil, fmt.Errorf("invalid commit hash")
+	}
+	c, ok := s.commits[req.path[0]]
+	if !ok {
+		return nil, nil
+	}
+	parents := append([]string{}, c.parents...)
+	return map[string]interface{}{"hash": c.hash, "parents": parents, "task": &c.task}, nil
+}
+
+func (s *Server) mergeTree(req *request) (interface{}, error) {
+	if len(req.path) != 1 {
+		return nil, fmt.Errorf("invalid branch or commit")
+	}
+	head, err := s.branchOf(req)
+	if err != nil {
+		return nil, err
+	}
+	var other string
+	if req.command == "merge-head" {
+		if other, err = s.commitOf(req); err != nil {
+			return nil, err
+		}
+	} else if other, _ = s.headOf(req.path[0]); other == "" {
+		return nil, fmt.Errorf("unknown branch %s", req.path[0])
+	}
+
+	switch {
+	case s.isAncestor(other, head): // nothing to merge
+	case head == "" || s.isAncestor(head, other): // fast-forward
+		s.setHead(req.tree, other)
+	default:
+		var base tree
+		if lcas := s.lcas(head, other); len(lcas) > 0 {
+			base = s.treeOf(lcas[0])
+		}
+		merged, err := merge(base, s.treeOf(head), s.treeOf(other))
+		if err != nil {
+			return map[string]interface{}{"conflict": err.Error()}, nil
+		}
+		if _, err := s.commit(req, merged, other); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]interface{}{"ok": nil}, nil
+}
+
+func (s *Server) lca(req *request) (interface{}, error) {
+	if len(req.path) != 2 {
+		return nil, fmt.Errorf("lca expects two commits")
+	}
+	for _, h := range req.path {
+		if _, ok := s.commits[h]; !ok {
+			return nil, fmt.Errorf("unknown commit %s", h)
+		}
+	}
+	return s.lcas(req.path[0], req.path[1]), nil
+}
+
+// ancestors returns hash and all its ancestors
+func (s *Server) ancestors(hash string) map[string]bool {
+	res := make(map[string]bool)
+	queue := []string{hash}
+	for len(queue) > 0 {
+		h := queue[0]
+		queue = queue[1:]
+		if res[h] {
+			continue
+		}
+		res[h] = true
+		if c, ok := s.commits[h]; ok {
+			queue = append(queue, c.parents...)
+		}
+	}
+	return res
+}
+
+// lcas returns the common ancestors of a and b that are not ancestors of other common ancestors
+func (s *Server) lcas(a, b string) []string {
+	inA := s.ancestors(a)
+	var common []string
+	for h := range s.ancestors(b) {
+		if inA[h] {
+			common = append(common, h)
+		}
+	}
+	res := []string{}
+	for _, h := range common {
+		lowest := true
+		for _, o := range common {
+			if o != h && s.isAncestor(h, o) {
+				lowest = false
+				break
+			}
+		}
+		if lowest {
+			res = append(res, h)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// isAncestor returns true if commit a is b or one of its ancestors
+func (s *Server) isAncestor(a, b string) bool {
+	return s.ancestors(b)[a]
+}
+
+func (s *Server) iter(req *request, w *streamWriter, r *http.Request) {
+	s.mu.Lock()
+	head, _ := s.headOf(req.tree)
+	keys := s.treeOf(head).keys()
+	s.mu.Unlock()
+
+	w.start()
+	for _, k := range keys {
+		if w.result(toPath(splitKey(k))) != nil {
+			return
+		}
+	}
+	w.end()
+}
+
+func (s *Server) watch(req *request, w *streamWriter, r *http.Request) {
+	k := keyOf(req.path)
+	s.watchStream(req, w, r, func(old, cur tree, hash string) interface{} {
+		v, ok := cur[k]
+		if prev, existed := old[k]; !ok || (existed && string(prev) == string(v)) {
+			return nil
+		}
+		return [][]irmin.Value{{irmin.NewValue(hash), v}}
+	})
+}
+
+func (s *Server) watchRec(req *request, w *streamWriter, r *http.Request) {
+	s.watchStream(req, w, r, func(old, cur tree, hash string) interface{} {
+		changes := old.diff(cur, req.path)
+		if len(changes) == 0 {
+			return nil
+		}
+		return []interface{}{hash, changes}
+	})
+}
+
+// watchStream streams the results of diff for every new head of the request tree until the client disconnects.
+// diff returns nil if there is nothing to report for a commit. If the first parameter contains a commit hash, changes
+// since that commit are reported first.
+func (s *Server) watchStream(req *request, w *streamWriter, r *http.Request, diff func(old, cur tree, hash string) interface{}) {
+	var first string
+	if len(req.params) > 0 {
+		var params []irmin.Value
+		if err := json.Unmarshal(req.params, &params); err != nil || len(params) == 0 {
+			writeReply(w.w, http.StatusBadRequest, nil, fmt.Errorf("invalid watch parameters: %s", req.params))
+			return
+		}
+		first = params[0].String()
+	}
+
+	wt := &watcher{branch: req.tree, signal: make(chan struct{}, 1)}
+	s.mu.Lock()
+	head, detached := s.headOf(req.tree)
+	if !detached {
+		s.watchers[wt] = true
+	}
+	if first != "" && first != head {
+		wt.push(first, head)
+	}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.watchers, wt)
+		s.mu.Unlock()
+	}()
+
+	w.start()
+	for {
+		select {
+		case <-wt.signal:
+		case <-r.Context().Done():
+			return
+		case <-s.closed:
+			w.end()
+			return
+		}
+
+		var results []interface{}
+		s.mu.Lock()
+		for _, u := range wt.updates {
+			if res := diff(s.treeOf(u[0]), s.treeOf(u[1]), u[1]); res != nil {
+				results = append(results, res)
+			}
+		}
+		wt.updates = nil
+		s.mu.Unlock()
+
+		for _, res := range results {
+			if w.result(res) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) viewCreate(req *request) (interface{}, error) {
+	head, _ := s.headOf(req.tree)
+	sub := s.treeOf(head).subtree(req.path)
+	node := s.addView(&view{head: head, origin: sub, tree: sub})
+	return head + "-" + node, nil
+}
+
+// addView stores a view and returns its node id
+func (s *Server) addView(v *view) string {
+	s.n++
+	node := fmt.Sprintf("%040x", s.n)
+	s.views[node] = v
+	return node
+}
+
+// viewOf returns the view of a request
+func (s *Server) viewOf(req *request) (*view, error) {
+	v, ok := s.views[req.node]
+	if !ok {
+		return nil, fmt.Errorf("unknown view %s", req.node)
+	}
+	return v, nil
+}
+
+func (s *Server) viewRead(req *request) (interface{}, error) {
+	v, err := s.viewOf(req)
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := v.tree[keyOf(req.path)]; ok {
+		return value(c), nil
+	}
+	return nil, nil
+}
+
+func (s *Server) viewUpdate(req *request) (interface{}, error) {
+	v, err := s.viewOf(req)
+	if err != nil {
+		return nil, err
+	}
+	var c irmin.Value
+	if err := json.Unmarshal(req.params, &c); err != nil {
+		return nil, err
+	}
+	t := v.tree.copy()
+	t[keyOf(req.path)] = c
+	return s.addView(&view{head: v.head, origin: v.origin, tree: t}), nil
+}
+
+func (s *Server) viewRemove(req *request) (interface{}, error) {
+	v, err := s.viewOf(req)
+	if err != nil {
+		return nil, err
+	}
+	t := v.tree.copy()
+	delete(t, keyOf(req.path))
+	return s.addView(&view{head: v.head, origin: v.origin, tree: t}), nil
+}
+
+func (s *Server) viewRemoveRec(req *request) (interface{}, error) {
+	v, err := s.viewOf(req)
+	if err != nil {
+		return nil, err
+	}
+	return s.addView(&view{head: v.head, origin: v.origin, tree: v.tree.replace(req.path, nil)}), nil
+}
+
+func (s *Server) viewList(req *request) (interface{}, error) {
+	v, err := s.viewOf(req)
+	if err != nil {
+		return nil, err
+	}
+	return v.tree.children(req.path), nil
+}
+
+func (s *Server) viewMem(req *request) (interface{}, error) {
+	v, err := s.viewOf(req)
+	if err != nil {
+		return nil, err
+	}
+	_, ok := v.tree[keyOf(req.path)]
+	return ok, nil
+}
+
+func (s *Server) viewMergePath(req *request) (interface{}, error) {
+	v, err := s.viewOf(req)
+	if err != nil {
+		return nil, err
+	}
+	head, _ := s.headOf(req.tree)
+	cur := s.treeOf(head)
+	merged, err := merge(v.origin, cur.subtree(req.path), v.tree)
+	if err != nil {
+		return map[string]interface{}{"conflict": err.Error()}, nil
+	}
+	var parents []string
+	if v.head != "" && v.head != head {
+		parents = append(parents, v.head)
+	}
+	if _, err := s.commit(req, cur.replace(req.path, merged), parents...); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"ok": nil}, nil
+}
+
+func (s *Server) viewUpdatePath(req *request) (interface{}, error) {
+	v, err := s.viewOf(req)
+	if err != nil {
+		return nil, err
+	}
+	head, _ := s.headOf(req.tree)
+	return s.commit(req, s.treeOf(head).replace(req.path, v.tree))
+}
+
+func (s *Server) viewIter(req *request, w *streamWriter, r *http.Request) {
+	s.mu.Lock()
+	v, err := s.viewOf(req)
+	var keys []string
+	if err == nil {
+		keys = v.tree.keys()
+	}
+	s.mu.Unlock()
+	if err != nil {
+		writeReply(w.w, http.StatusOK, nil, err)
+		return
+	}
+
+	w.start()
+	for _, k := range keys {
+		if w.result(toPath(splitKey(k))) != nil {
+			return
+		}
+	}
+	w.end()
+}
+
+// watcher receives head updates for a branch
+type watcher struct {
+	branch  string
+	updates [][2]string   // pending old/new commit pairs, protected by Server.mu
+	signal  chan struct{} // signalled when updates are added
+}
+
+func (w *watcher) push(old, cur string) {
+	w.updates = append(w.updates, [2]string{old, cur})
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+}
+
+// streamWriter writes a streamed reply: [{"stream":"start"},{"version":...},{"result":...},...,{"stream":"end"}]
+type streamWriter struct {
+	w http.ResponseWriter
+}
+
+func newStreamWriter(w http.ResponseWriter) *streamWriter {
+	return &streamWriter{w}
+}
+
+func (w *streamWriter) write(s string) error {
+	if _, err := fmt.Fprint(w.w, s); err != nil {
+		return err
+	}
+	w.w.(http.Flusher).Flush()
+	return nil
+}
+
+func (w *streamWriter) start() error {
+	w.w.Header().Set("Content-Type", "application/json")
+	return w.write(fmt.Sprintf(`[{"stream":"start"},{"version":%q}`, Version))
+}
+
+func (w *streamWriter) result(res interface{}) error {
+	j, err := json.Marshal(map[string]interface{}{"result": res})
+	if err != nil {
+		return err
+	}
+	return w.write("," + string(j))
+}
+
+func (w *streamWriter) end() error {
+	return w.write(`,{"stream":"end"}]`)
+}
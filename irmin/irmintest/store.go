@@ -0,0 +1,218 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmintest
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/MagnusS/irmin-go/irmin"
+)
+
+// tree maps keys to values. Keys are path segments joined by keySep.
+type tree map[string][]byte
+
+const keySep = "\x00"
+
+func keyOf(path []string) string {
+	return strings.Join(path, keySep)
+}
+
+func splitKey(key string) []string {
+	if key == "" {
+		return nil
+	}
+	return strings.Split(key, keySep)
+}
+
+// hasPrefix returns true if key is equal to or below path
+func hasPrefix(key string, path []string) bool {
+	if len(path) == 0 {
+		return true
+	}
+	p := keyOf(path)
+	return key == p || strings.HasPrefix(key, p+keySep)
+}
+
+// toPath converts path segments to an irmin.Path
+func toPath(path []string) irmin.Path {
+	p := make(irmin.Path, len(path))
+	for i, s := range path {
+		p[i] = irmin.NewValue(s)
+	}
+	return p
+}
+
+func (t tree) copy() tree {
+	c := make(tree, len(t))
+	for k, v := range t {
+		c[k] = v
+	}
+	return c
+}
+
+// subtree returns the keys below path, relative to path
+func (t tree) subtree(path []string) tree {
+	sub := make(tree)
+	for k, v := range t {
+		if hasPrefix(k, path) && k != keyOf(path) {
+			sub[keyOf(splitKey(k)[len(path):])] = v
+		}
+	}
+	return sub
+}
+
+// replace returns a copy of t where everything below path is replaced by sub
+func (t tree) replace(path []string, sub tree) tree {
+	c := make(tree, len(t))
+	for k, v := range t {
+		if !hasPrefix(k, path) {
+			c[k] = v
+		}
+	}
+	for k, v := range sub {
+		c[keyOf(append(append([]string{}, path...), splitKey(k)...))] = v
+	}
+	return c
+}
+
+// children returns the paths directly below path, as returned by list
+func (t tree) children(path []string) []irmin.Path {
+	children := make(tree)
+	for k := range t {
+		if hasPrefix(k, path) && k != keyOf(path) {
+			children[keyOf(splitKey(k)[:len(path)+1])] = nil
+		}
+	}
+	res := []irmin.Path{}
+	for _, k := range children.keys() {
+		res = append(res, toPath(splitKey(k)))
+	}
+	return res
+}
+
+// keys returns the sorted keys in t
+func (t tree) keys() []string {
+	keys := make([]string, 0, len(t))
+	for k := range t {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// diff returns the changes below path from t to other in WatchPath format
+func (t tree) diff(other tree, path []string) [][2]interface{} {
+	all := make(tree)
+	for k := range t {
+		all[k] = nil
+	}
+	for k := range other {
+		all[k] = nil
+	}
+	var changes [][2]interface{}
+	for _, k := range all.keys() {
+		if !hasPrefix(k, path) {
+			continue
+		}
+		old, inOld := t[k]
+		cur, inCur := other[k]
+		var change string
+		switch {
+		case !inOld && inCur:
+			change = irmin.KeyCreated
+		case inOld && !inCur:
+			change = irmin.KeyDeleted
+		case !bytes.Equal(old, cur):
+			change = irmin.KeyUpdated
+		default:
+			continue
+		}
+		changes = append(changes, [2]interface{}{change, toPath(splitKey(k))})
+	}
+	return changes
+}
+
+// merge does a three way merge of ours and theirs using base as the common ancestor
+func merge(base, ours, theirs tree) (tree, error) {
+	all := make(tree)
+	for _, t := range []tree{base, ours, theirs} {
+		for k := range t {
+			all[k] = nil
+		}
+	}
+	res := make(tree)
+	for _, k := range all.keys() {
+		b, inBase := base[k]
+		o, inOurs := ours[k]
+		t, inTheirs := theirs[k]
+		switch {
+		case inOurs == inTheirs && bytes.Equal(o, t): // same on both sides
+		case inBase == inOurs && bytes.Equal(b, o): // only changed by theirs
+			o, inOurs = t, inTheirs
+		case inBase == inTheirs && bytes.Equal(b, t): // only changed by us
+		default:
+			p := toPath(splitKey(k))
+			return nil, fmt.Errorf("conflict on %s", p.String())
+		}
+		if inOurs {
+			res[k] = o
+		}
+	}
+	return res, nil
+}
+
+// commit is a commit in the store
+type commit struct {
+	hash    string
+	parents []string
+	task    irmin.Task
+	tree    tree
+}
+
+// newCommit creates a commit and computes its hash. n makes the hash unique for otherwise identical commits.
+func newCommit(n int, parents []string, task irmin.Task, t tree) *commit {
+	h := sha1.New()
+	fmt.Fprintf(h, "%d\n", n)
+	for _, p := range parents {
+		fmt.Fprintf(h, "parent %s\n", p)
+	}
+	fmt.Fprintf(h, "task %s %s %s\n", task.Date, task.Owner.String(), task.UID)
+	for _, m := range task.Messages {
+		fmt.Fprintf(h, "message %s\n", m.String())
+	}
+	for _, k := range t.keys() {
+		fmt.Fprintf(h, "%x %x\n", k, t[k])
+	}
+	return &commit{
+		hash:    hex.EncodeToString(h.Sum(nil)),
+		parents: parents,
+		task:    task,
+		tree:    t,
+	}
+}
+
+// view is an Irmin view (transaction). Views are immutable, every update creates a new node.
+type view struct {
+	head   string // commit the view was created from
+	origin tree   // contents when the view was created, relative to the view path
+	tree   tree   // current contents, relative to the view path
+}
@@ -16,15 +16,168 @@
 
 package irmin
 
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
 // Log is a generic log interface that implements a Printf function
 type Log interface {
 	Printf(format string, args ...interface{})
 }
 
-// IgnoreLog is an implementation of Log that ignores all log messages
+// Level is the severity of a log message. The levels have the same values as the log/slog levels.
+type Level int
+
+const (
+	// LevelDebug is used for every call and stream
+	LevelDebug Level = -4
+	// LevelInfo is used for retried calls and transactions
+	LevelInfo Level = 0
+	// LevelWarn is used for failed calls and watches that are restarted
+	LevelWarn Level = 4
+	// LevelError is used for errors that are not returned to the caller
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	return slog.Level(l).String()
+}
+
+// Field is a key/value pair added to a log message
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger is a leveled, structured logger, see WithLogger. Log must be safe for concurrent use.
+type Logger interface {
+	Log(level Level, msg string, fields ...Field)
+}
+
+// IgnoreLog is an implementation of Log and Logger that ignores all log messages
 type IgnoreLog struct{}
 
 // Printf implementation that ignores its input and returns
 func (i IgnoreLog) Printf(format string, args ...interface{}) {
 	return
 }
+
+// Log implementation that ignores its input and returns
+func (i IgnoreLog) Log(level Level, msg string, fields ...Field) {
+	return
+}
+
+// NewLogAdapter returns a Logger that writes messages at or above level min to log, formatted as
+// "LEVEL msg key=value ...".
+func NewLogAdapter(log Log, min Level) Logger {
+	return &printfLogger{log, min}
+}
+
+type printfLogger struct {
+	log Log
+	min Level
+}
+
+func (p *printfLogger) Log(level Level, msg string, fields ...Field) {
+	if level < p.min {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s", level, msg)
+	for _, f := range fields {
+		v := fmt.Sprint(f.Value)
+		if v == "" || strings.ContainsAny(v, " \"=\n") {
+			v = fmt.Sprintf("%q", v)
+		}
+		fmt.Fprintf(&b, " %s=%s", f.Key, v)
+	}
+	p.log.Printf("%s\n", b.String())
+}
+
+// NewSlogLogger returns a Logger that writes messages to l. Fields are added as attributes.
+func NewSlogLogger(l *slog.Logger) Logger {
+	return &slogLogger{l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s *slogLogger) Log(level Level, msg string, fields ...Field) {
+	ctx := context.Background()
+	if !s.l.Enabled(ctx, slog.Level(level)) {
+		return
+	}
+	attrs := make([]slog.Attr, len(fields))
+	for i, f := range fields {
+		attrs[i] = slog.Any(f.Key, f.Value)
+	}
+	s.l.LogAttrs(ctx, slog.Level(level), msg, attrs...)
+}
+
+// Redaction controls which values are included in log messages, see WithRedaction. Values are hidden by default.
+type Redaction struct {
+	ShowValues bool   // Log the values in requests and replies
+	Hidden     []Path // Values below these paths are hidden even if ShowValues is set. Also hides view values.
+}
+
+// redactedValue replaces hidden values in log messages
+const redactedValue = "[redacted]"
+
+// pathCommands are the commands sent by this package with a key path in the URL, outside views. Hidden paths can
+// only be checked for these, see hide.
+var pathCommands = map[string]bool{
+	"read":            true,
+	"mem":             true,
+	"list":            true,
+	"iter":            true,
+	"update":          true,
+	"remove":          true,
+	"remove-rec":      true,
+	"compare-and-set": true,
+	"watch":           true,
+	"watch-rec":       true,
+}
+
+// otherCommands are the commands sent by this package without a key path in the URL
+var otherCommands = map[string]bool{
+	"":                     true, // list of commands
+	"head":                 true,
+	"branches":             true,
+	"commit":               true,
+	"lca":                  true,
+	"remove-branch":        true,
+	"update-head":          true,
+	"fast-forward-head":    true,
+	"compare-and-set-head": true,
+}
+
+// hide returns true if the values sent to or received from command on path should not be logged. Paths in view
+// commands are relative to the view, so view values are only shown if no paths are hidden. Values of commands that
+// are not recognised, e.g. because the URL could not be parsed, are also hidden if any paths are hidden.
+func (r *Redaction) hide(command string, path Path) bool {
+	if !r.ShowValues {
+		return true
+	}
+	if len(r.Hidden) == 0 {
+		return false
+	}
+	if path == nil { // the URL could not be parsed
+		return true
+	}
+	if otherCommands[command] {
+		return false
+	}
+	if !pathCommands[command] {
+		return true
+	}
+	for _, h := range r.Hidden {
+		if hasPathPrefix(path, h) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin_test
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/MagnusS/irmin-go/irmin"
+)
+
+type logEntry struct {
+	level  irmin.Level
+	msg    string
+	fields map[string]interface{}
+}
+
+type testLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (l *testLogger) Log(level irmin.Level, msg string, fields ...irmin.Field) {
+	e := logEntry{level, msg, make(map[string]interface{})}
+	for _, f := range fields {
+		e.fields[f.Key] = f.Value
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, e)
+}
+
+// call returns the last call log entry for command
+func (l *testLogger) call(t *testing.T, command string) logEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		if e := l.entries[i]; e.msg == "irmin call" && e.fields["command"] == command {
+			return e
+		}
+	}
+	t.Fatalf("no log entry for %s in %v", command, l.entries)
+	return logEntry{}
+}
+
+type printfLog struct {
+	bytes.Buffer
+}
+
+func (p *printfLog) Printf(format string, args ...interface{}) {
+	fmt.Fprintf(&p.Buffer, format, args...)
+}
+
+func TestLogger(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	l := new(testLogger)
+	r := srv.Conn("irmin-go-tester", irmin.WithLogger(l))
+	if _, err := r.Update(r.NewTask("update"), irmin.ParsePath("/a/b"), []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	e := l.call(t, "update")
+	if e.level != irmin.LevelDebug || e.fields["path"] != "/a/b" || e.fields["status"] != 200 {
+		t.Errorf("unexpected log entry %v", e)
+	}
+	if e.fields["request"] != "[redacted]" || e.fields["reply"] != "[redacted]" {
+		t.Errorf("values should be hidden by default, got %v", e)
+	}
+
+	r = srv.Conn("irmin-go-tester", irmin.WithLogger(l),
+		irmin.WithRedaction(irmin.Redaction{ShowValues: true, Hidden: []irmin.Path{irmin.ParsePath("/secrets")}}))
+	if _, err := r.Update(r.NewTask("update"), irmin.ParsePath("/public/a"), []byte("visible")); err != nil {
+		t.Fatal(err)
+	}
+	if e := l.call(t, "update"); !strings.Contains(e.fields["request"].(string), "visible") {
+		t.Errorf("expected value in log entry, got %v", e)
+	}
+	if _, err := r.Update(r.NewTask("update"), irmin.ParsePath("/secrets/a"), []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if e := l.call(t, "update"); e.fields["request"] != "[redacted]" || e.fields["path"] != "/secrets/a" {
+		t.Errorf("expected hidden value below /secrets, got %v", e)
+	}
+	if _, err := r.FromTree("feature/x").Update(r.NewTask("update"), irmin.ParsePath("/secrets/a"), []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if e := l.call(t, "update"); e.fields["request"] != "[redacted]" || e.fields["tree"] != "feature/x" {
+		t.Errorf("expected hidden value below /secrets on tree feature/x, got %v", e)
+	}
+	if _, err := r.FromTree("feature/x").Read(irmin.ParsePath("/secrets/a")); err != nil {
+		t.Fatal(err)
+	}
+	if e := l.call(t, "read"); e.fields["reply"] != "[redacted]" {
+		t.Errorf("expected hidden reply below /secrets on tree feature/x, got %v", e)
+	}
+	v, err := r.CreateView(r.NewTask("view"), irmin.ParsePath("/secrets"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Update(r.NewTask("update"), irmin.ParsePath("a"), []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if e := l.call(t, "view/update"); e.fields["request"] != "[redacted]" {
+		t.Errorf("expected hidden value in view, got %v", e)
+	}
+
+	var p printfLog
+	r = srv.Conn("irmin-go-tester", irmin.WithLogger(irmin.NewLogAdapter(&p, irmin.LevelDebug)))
+	if _, err := r.Read(irmin.ParsePath("/a/b")); err != nil {
+		t.Fatal(err)
+	}
+	if s := p.String(); !strings.HasPrefix(s, "DEBUG irmin call command=read path=/a/b ") || strings.Contains(s, "secret") {
+		t.Errorf("unexpected log output %q", s)
+	}
+	p.Reset()
+	r = srv.Conn("irmin-go-tester", irmin.WithLogger(irmin.NewLogAdapter(&p, irmin.LevelInfo)))
+	if _, err := r.Read(irmin.ParsePath("/a/b")); err != nil {
+		t.Fatal(err)
+	}
+	if p.Len() != 0 {
+		t.Errorf("debug message should be filtered, got %q", p.String())
+	}
+
+	var buf bytes.Buffer
+	sl := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	r = srv.Conn("irmin-go-tester", irmin.WithLogger(irmin.NewSlogLogger(sl)))
+	if _, err := r.Read(irmin.ParsePath("/a/b")); err != nil {
+		t.Fatal(err)
+	}
+	if s := buf.String(); !strings.Contains(s, "level=DEBUG") || !strings.Contains(s, "command=read") || strings.Contains(s, "secret") {
+		t.Errorf("unexpected slog output %q", s)
+	}
+}
@@ -0,0 +1,92 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+)
+
+type lcaReply stringArrayReply
+
+// Merge merges the branch or commit other into the current tree. A new merge commit is created unless the current
+// tree can be fast-forwarded. If the merge fails a *ConflictError describing the conflict is returned and the current
+// tree is not changed.
+func (rest *Conn) Merge(t Task, other string) error {
+	return rest.MergeContext(context.Background(), t, other)
+}
+
+// MergeContext is like Merge, but the request is aborted if ctx is cancelled.
+func (rest *Conn) MergeContext(ctx context.Context, t Task, other string) error {
+	return rest.merge(ctx, t, "merge", Path{NewValue(other)})
+}
+
+// MergeHead merges a commit into the current tree, like Merge
+func (rest *Conn) MergeHead(t Task, commit []byte) error {
+	return rest.MergeHeadContext(context.Background(), t, commit)
+}
+
+// MergeHeadContext is like MergeHead, but the request is aborted if ctx is cancelled.
+func (rest *Conn) MergeHeadContext(ctx context.Context, t Task, commit []byte) error {
+	return rest.merge(ctx, t, "merge-head", hashPath(commit))
+}
+
+func (rest *Conn) merge(ctx context.Context, t Task, command string, path Path) error {
+	var data mergeReply
+	uri, err := rest.MakeCallURL(command, path, true)
+	if err != nil {
+		return err
+	}
+	body := postRequest{t, nil}
+	if err = rest.CallContext(ctx, uri, &body, &data); err != nil {
+		return err
+	}
+	if err = data.err(); err != nil {
+		return err
+	}
+	return mergeResult(data.Result)
+}
+
+// LCA returns the lowest common ancestors of two commits. Usually there is only one, but there may be more if the
+// histories have been merged in both directions. Returns an empty list if the commits have no common history.
+func (rest *Conn) LCA(commitA []byte, commitB []byte) ([][]byte, error) {
+	return rest.LCAContext(context.Background(), commitA, commitB)
+}
+
+// LCAContext is like LCA, but the request is aborted if ctx is cancelled.
+func (rest *Conn) LCAContext(ctx context.Context, commitA []byte, commitB []byte) ([][]byte, error) {
+	var data lcaReply
+	path := append(hashPath(commitA), hashPath(commitB)...)
+	uri, err := rest.MakeCallURL("lca", path, false)
+	if err != nil {
+		return nil, err
+	}
+	if err = rest.CallContext(ctx, uri, nil, &data); err != nil {
+		return nil, err
+	}
+	if err = data.err(); err != nil {
+		return nil, err
+	}
+	lcas := make([][]byte, len(data.Result))
+	for i, v := range data.Result {
+		if lcas[i], err = hex.DecodeString(v.String()); err != nil {
+			return nil, &ProtocolError{fmt.Sprintf("unable to parse hash %s", v.String()), err}
+		}
+	}
+	return lcas, nil
+}
@@ -0,0 +1,106 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/MagnusS/irmin-go/irmin"
+)
+
+func TestMerge(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	r := getConn(t, srv)
+	if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/merge/a"), []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	base := mustHead(t, r)
+	if err := r.Clone(r.NewTask("clone"), "staging", false); err != nil {
+		t.Fatal(err)
+	}
+	staging := r.FromTree("staging")
+
+	// Fast-forward
+	if _, err := staging.Update(r.NewTask("update key"), irmin.ParsePath("/merge/b"), []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Merge(r.NewTask("merge staging"), "staging"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := r.ReadString(irmin.ParsePath("/merge/b")); err != nil || v != "foo" {
+		t.Fatalf("expected foo after merge, got %s %v", v, err)
+	}
+
+	// Diverged, but no conflict
+	if _, err := staging.Update(r.NewTask("update key"), irmin.ParsePath("/merge/c"), []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/merge/d"), []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	stagingHead, head := mustHead(t, staging), mustHead(t, r)
+	lcas, err := r.LCA(head, stagingHead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lcas) != 1 || bytes.Equal(lcas[0], base) || bytes.Equal(lcas[0], head) {
+		t.Fatalf("unexpected common ancestors %x", lcas)
+	}
+	if err := r.MergeHead(r.NewTask("merge staging"), stagingHead); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"/merge/c", "/merge/d"} {
+		if v, err := r.ReadString(irmin.ParsePath(k)); err != nil || v != "foo" {
+			t.Fatalf("expected foo in %s after merge, got %s %v", k, v, err)
+		}
+	}
+	c, err := r.Commit(mustHead(t, r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Parents) != 2 {
+		t.Fatalf("expected merge commit with 2 parents, got %d", len(c.Parents))
+	}
+
+	// Conflict
+	if _, err := staging.Update(r.NewTask("update key"), irmin.ParsePath("/merge/a"), []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/merge/a"), []byte("baz")); err != nil {
+		t.Fatal(err)
+	}
+	head = mustHead(t, r)
+	var conflict *irmin.ConflictError
+	if err := r.Merge(r.NewTask("merge staging"), "staging"); !errors.As(err, &conflict) {
+		t.Fatalf("expected ConflictError, got %v", err)
+	}
+	if !bytes.Equal(mustHead(t, r), head) {
+		t.Fatal("head should not change on conflict")
+	}
+}
+
+func mustHead(t *testing.T, r *irmin.Conn) []byte {
+	h, err := r.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
@@ -0,0 +1,155 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// CommandStats contains the measurements for one Irmin command, see Metrics
+type CommandStats struct {
+	Requests      int64         // Finished requests, including requests that started a stream
+	Errors        int64         // Requests that failed, see RequestInfo.Err
+	InFlight      int64         // Requests that have been started, but not finished
+	Retries       int64         // Attempts after the first
+	Status        map[int]int64 // Number of replies by HTTP status
+	BytesSent     int64
+	BytesReceived int64         // Bytes received in replies and streams
+	Duration      time.Duration // Total duration of finished requests
+	MaxDuration   time.Duration // Duration of the slowest request
+	Streams       int64         // Streams opened
+	OpenStreams   int64         // Streams opened, but not closed
+	StreamItems   int64         // Items received from streams
+	StreamErrors  int64         // Streams that failed or were cancelled
+}
+
+// MeanDuration returns the mean duration of finished requests
+func (s CommandStats) MeanDuration() time.Duration {
+	if s.Requests == 0 {
+		return 0
+	}
+	return s.Duration / time.Duration(s.Requests)
+}
+
+// Metrics is an Instrumentation that keeps counters per command in memory. It implements expvar.Var, so it can be
+// published with expvar.Publish.
+type Metrics struct {
+	mu       sync.Mutex
+	commands map[string]*CommandStats
+}
+
+// NewMetrics returns a new Metrics with no measurements
+func NewMetrics() *Metrics {
+	return &Metrics{commands: make(map[string]*CommandStats)}
+}
+
+// command returns the stats for command. The caller must hold m.mu.
+func (m *Metrics) command(command string) *CommandStats {
+	s, ok := m.commands[command]
+	if !ok {
+		s = &CommandStats{Status: make(map[int]int64)}
+		m.commands[command] = s
+	}
+	return s
+}
+
+// RequestStarted implements Instrumentation
+func (m *Metrics) RequestStarted(command string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.command(command).InFlight++
+}
+
+// RequestFinished implements Instrumentation
+func (m *Metrics) RequestFinished(r RequestInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.command(r.Command)
+	s.InFlight--
+	s.Requests++
+	if r.Err != nil {
+		s.Errors++
+	}
+	if r.Attempts > 1 {
+		s.Retries += int64(r.Attempts - 1)
+	}
+	if r.Status != 0 {
+		s.Status[r.Status]++
+	}
+	s.BytesSent += r.BytesSent
+	s.BytesReceived += r.BytesReceived
+	s.Duration += r.Duration
+	if r.Duration > s.MaxDuration {
+		s.MaxDuration = r.Duration
+	}
+}
+
+// StreamOpened implements Instrumentation
+func (m *Metrics) StreamOpened(command string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.command(command)
+	s.Streams++
+	s.OpenStreams++
+}
+
+// StreamItem implements Instrumentation
+func (m *Metrics) StreamItem(command string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.command(command).StreamItems++
+}
+
+// StreamClosed implements Instrumentation
+func (m *Metrics) StreamClosed(si StreamInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.command(si.Command)
+	s.OpenStreams--
+	s.BytesReceived += si.BytesReceived
+	if si.Err != nil {
+		s.StreamErrors++
+	}
+}
+
+// Stats returns a copy of the measurements, by command
+func (m *Metrics) Stats() map[string]CommandStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := make(map[string]CommandStats, len(m.commands))
+	for k, s := range m.commands {
+		c := *s
+		c.Status = make(map[int]int64, len(s.Status))
+		for status, n := range s.Status {
+			c.Status[status] = n
+		}
+		r[k] = c
+	}
+	return r
+}
+
+// String returns the measurements as a JSON object keyed by command, as required by expvar.Var. Durations are in
+// nanoseconds.
+func (m *Metrics) String() string {
+	j, err := json.Marshal(m.Stats())
+	if err != nil { // can't happen, all fields are numbers
+		return "{}"
+	}
+	return string(j)
+}
@@ -0,0 +1,85 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin_test
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"github.com/MagnusS/irmin-go/irmin"
+)
+
+func TestMetrics(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	m := irmin.NewMetrics()
+	r := srv.Conn("irmin-go-tester", irmin.WithInstrumentation(m))
+
+	if _, err := r.Update(r.NewTask("update"), irmin.ParsePath("/a/b"), []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.FromTree("master").Read(irmin.ParsePath("/a/b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(irmin.ParsePath("/a/c")); err == nil {
+		t.Fatal("expected /a/c to not exist")
+	}
+	ch, err := r.Iter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for it := range ch {
+		if it.Error != nil {
+			t.Fatal(it.Error)
+		}
+	}
+
+	stats := m.Stats()
+	update, read, iter := stats["update"], stats["read"], stats["iter"]
+	if update.Requests != 1 || update.Status[200] != 1 || update.BytesSent == 0 || update.BytesReceived == 0 {
+		t.Errorf("unexpected update stats %+v", update)
+	}
+	if read.Requests != 2 || read.Errors != 0 || read.InFlight != 0 || read.MaxDuration == 0 {
+		t.Errorf("unexpected read stats %+v", read) // not found is returned by Irmin, not a failed request
+	}
+	if iter.Requests != 1 || iter.Streams != 1 || iter.OpenStreams != 0 || iter.StreamItems != 1 || iter.StreamErrors != 0 {
+		t.Errorf("unexpected iter stats %+v", iter)
+	}
+
+	var published map[string]irmin.CommandStats
+	if err := json.Unmarshal([]byte(m.String()), &published); err != nil {
+		t.Fatal(err)
+	}
+	if published["update"].Requests != 1 {
+		t.Errorf("unexpected JSON %s", m.String())
+	}
+
+	stopped := spawnIrmin(t)
+	stopIrmin(t, stopped)
+	uri, err := url.Parse(stopped.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := irmin.Create(uri, "irmin-go-tester", irmin.WithInstrumentation(m)).Head(); err == nil {
+		t.Fatal("expected error from stopped server")
+	}
+	if head := m.Stats()["head"]; head.Requests != 1 || head.Errors != 1 || len(head.Status) != 0 {
+		t.Errorf("unexpected head stats %+v", head)
+	}
+}
@@ -0,0 +1,100 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+// Request is a call or stream passed through the middleware chain, see WithMiddleware. Middleware can change the
+// URL, headers and POST body before calling the next handler.
+type Request struct {
+	Command string          // Irmin command, parsed from URL when the request was created. See RequestInfo.
+	Tree    string          // Tree in URL, empty for master
+	Path    Path            // Path in URL. Relative to the view for view commands.
+	URL     *url.URL        // URL created by MakeCallURL
+	Header  http.Header     // Extra headers sent with the request, before the headers set with WithHeader
+	Task    *Task           // Task in the POST body, nil for GET requests
+	Params  json.RawMessage // Parameters in the POST body
+	Stream  bool            // The request starts a stream
+	Reply   interface{}     // Value the reply is decoded into, as passed to Call. Nil for streams.
+}
+
+// Response is the result of a Request
+type Response struct {
+	Reply  interface{}         // The decoded reply, Request.Reply. Nil for streams.
+	Stream <-chan *StreamReply // Replies from the stream. Nil for calls.
+}
+
+// Handler sends a Request
+type Handler func(ctx context.Context, req *Request) (*Response, error)
+
+// Middleware wraps a Handler, e.g. to add headers, check replies or retry requests. The returned Handler must call
+// next to send the request, or return a Response with the Reply filled in or a Stream.
+type Middleware func(next Handler) Handler
+
+func newCall(uri *url.URL, post *postRequest, v interface{}) *Request {
+	req := &Request{URL: uri, Header: make(http.Header), Reply: v}
+	req.Tree, req.Command, req.Path = splitCallURL(uri)
+	if post != nil {
+		task := post.Task
+		req.Task = &task
+		req.Params = post.Data
+	}
+	return req
+}
+
+func newStream(uri *url.URL, post *postRequest) *Request {
+	req := newCall(uri, post, nil)
+	req.Stream = true
+	return req
+}
+
+// post returns the POST body of req, or nil for GET requests
+func (req *Request) post() *postRequest {
+	if req.Task == nil {
+		return nil
+	}
+	return &postRequest{Task: *req.Task, Data: req.Params}
+}
+
+// handler returns the Handler that sends requests through the middleware chain
+func (c *Client) handler() Handler {
+	h := Handler(c.handle)
+	for i := len(c.middleware) - 1; i >= 0; i-- {
+		h = c.middleware[i](h)
+	}
+	return h
+}
+
+// handle is the last Handler in the chain. It sends req to Irmin.
+func (c *Client) handle(ctx context.Context, req *Request) (*Response, error) {
+	if req.Stream {
+		ch, err := c.callStream(ctx, req.URL, req.post(), req.Header)
+		if err != nil {
+			return nil, err
+		}
+		return &Response{Stream: ch}, nil
+	}
+	if err := c.call(ctx, req.URL, req.post(), req.Header, req.Reply); err != nil {
+		return nil, err
+	}
+	return &Response{Reply: req.Reply}, nil
+}
@@ -0,0 +1,142 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin_test
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/MagnusS/irmin-go/irmin"
+)
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestMiddleware(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	var mu sync.Mutex
+	var order []string
+	var requestIDs []string
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		requestIDs = append(requestIDs, req.Header.Get("X-Request-Id"))
+		mu.Unlock()
+		return http.DefaultTransport.RoundTrip(req)
+	})
+	trace := func(name string) irmin.Middleware {
+		return func(next irmin.Handler) irmin.Handler {
+			return func(ctx context.Context, req *irmin.Request) (*irmin.Response, error) {
+				mu.Lock()
+				order = append(order, name+" "+req.Command)
+				mu.Unlock()
+				return next(ctx, req)
+			}
+		}
+	}
+	errDenied := errors.New("denied")
+	var replies []string
+	var streamItems int
+	custom := func(next irmin.Handler) irmin.Handler {
+		return func(ctx context.Context, req *irmin.Request) (*irmin.Response, error) {
+			req.Header.Set("X-Request-Id", "req-1")
+			switch req.Command {
+			case "mem":
+				return nil, errDenied // never sent
+			case "update":
+				req.Task.Messages[0] = irmin.NewValue("[tenant-a] " + req.Task.Message())
+			}
+			res, err := next(ctx, req)
+			if err != nil || !req.Stream {
+				if err == nil && req.Command == "read" {
+					j, _ := json.Marshal(res.Reply)
+					replies = append(replies, req.Path.String()+" "+string(j))
+				}
+				return res, err
+			}
+			ch := make(chan *irmin.StreamReply)
+			go func() {
+				defer close(ch)
+				for r := range res.Stream {
+					streamItems++
+					ch <- r
+				}
+			}()
+			return &irmin.Response{Stream: ch}, nil
+		}
+	}
+	r := srv.Conn("irmin-go-tester", irmin.WithMiddleware(trace("a"), trace("b")), irmin.WithMiddleware(custom),
+		irmin.WithHTTPClient(&http.Client{Transport: transport}))
+
+	hash, err := r.Update(r.NewTask("update"), irmin.ParsePath("/a/b"), []byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := hex.DecodeString(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := r.Commit(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := c.Task.Message(); msg != "[tenant-a] update" {
+		t.Errorf("expected task changed by middleware, got %q", msg)
+	}
+
+	if _, err := r.Read(irmin.ParsePath("/a/b")); err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 1 || !strings.HasPrefix(replies[0], "/a/b ") || !strings.Contains(replies[0], "foo") {
+		t.Errorf("unexpected decoded replies %v", replies)
+	}
+	if _, err := r.Mem(irmin.ParsePath("/a/b")); !errors.Is(err, errDenied) {
+		t.Errorf("expected error from middleware, got %v", err)
+	}
+
+	ch, err := r.Iter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range ch {
+	}
+	if streamItems != 1 {
+		t.Errorf("expected 1 stream item through middleware, got %d", streamItems)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expect := []string{"a update", "b update", "a commit", "b commit", "a read", "b read", "a mem", "b mem", "a iter", "b iter"}
+	if strings.Join(order, ",") != strings.Join(expect, ",") {
+		t.Errorf("expected middleware calls %v, got %v", expect, order)
+	}
+	for _, id := range requestIDs {
+		if id != "req-1" {
+			t.Errorf("expected header set by middleware, got %q", id)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+)
+
+// ClientOption configures a Client. Options are passed to NewClient or Create.
+type ClientOption func(*Client)
+
+// WithHTTPClient sets the http.Client used for all requests, e.g. to configure timeouts, TLS, proxies or a custom
+// RoundTripper. http.DefaultClient is used by default.
+func WithHTTPClient(hc *http.Client) ClientOption {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithLog sets the log implementation. All messages are written to log, see NewLogAdapter to filter by level. Log
+// messages are ignored by default.
+func WithLog(log Log) ClientOption {
+	return func(c *Client) {
+		c.logger = NewLogAdapter(log, LevelDebug)
+	}
+}
+
+// WithLogger sets the structured logger, e.g. NewSlogLogger(slog.Default()). Log messages are ignored by default.
+func WithLogger(logger Logger) ClientOption {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// WithRedaction sets which values are included in log messages. Values are hidden by default.
+func WithRedaction(r Redaction) ClientOption {
+	return func(c *Client) {
+		c.redaction = &r
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every request
+func WithUserAgent(userAgent string) ClientOption {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// WithHeader adds a header that is sent with every request. Can be given more than once.
+func WithHeader(key, value string) ClientOption {
+	return func(c *Client) {
+		c.header.Add(key, value)
+	}
+}
+
+// WithRetry retries failed calls according to p. Calls are not retried by default.
+func WithRetry(p RetryPolicy) ClientOption {
+	return func(c *Client) {
+		c.retry = &p
+	}
+}
+
+// WithReadConcurrency sets the maximum number of parallel reads made by ReadTree. The default is
+// DefaultReadConcurrency.
+func WithReadConcurrency(n int) ClientOption {
+	return func(c *Client) {
+		c.readConcurrency = n
+	}
+}
+
+// WithCodec sets the codec used by Get and Put. JSONCodec is used by default.
+func WithCodec(codec Codec) ClientOption {
+	return func(c *Client) {
+		c.valueCodec = codec
+	}
+}
+
+// WithInstrumentation sets the Instrumentation that receives measurements for every call and stream, e.g. a *Metrics.
+// Measurements are ignored by default.
+func WithInstrumentation(i Instrumentation) ClientOption {
+	return func(c *Client) {
+		c.instrumentation = i
+	}
+}
+
+// WithTracer sets the Tracer used to start a span for every call, stream and transaction. Calls are not traced by
+// default.
+func WithTracer(t Tracer) ClientOption {
+	return func(c *Client) {
+		c.tracer = t
+	}
+}
+
+// WithMiddleware adds middleware that wraps every call and stream. Can be given more than once. The first middleware
+// added is the outermost and sees the request first.
+func WithMiddleware(m ...Middleware) ClientOption {
+	return func(c *Client) {
+		c.middleware = append(c.middleware, m...)
+	}
+}
+
+// WithBasicAuth sends user and password with basic auth in every request. Replaces credentials given in the URL.
+func WithBasicAuth(user, password string) ClientOption {
+	return func(c *Client) {
+		c.basicAuth = url.UserPassword(user, password)
+	}
+}
+
+// WithTokenSource sends a bearer token from ts in every request. The token is used instead of basic auth.
+func WithTokenSource(ts TokenSource) ClientOption {
+	return func(c *Client) {
+		c.tokenSource = ts
+	}
+}
+
+// WithTLSConfig sets the TLS configuration, e.g. to trust a private CA. The configuration replaces the one in a copy of
+// the transport of the http.Client, which must be an *http.Transport. Calls fail if it is not.
+func WithTLSConfig(cfg *tls.Config) ClientOption {
+	return func(c *Client) {
+		c.tlsConfig = new(tls.Config)
+		if cfg != nil {
+			c.tlsConfig = cfg.Clone()
+		}
+	}
+}
+
+// WithClientCertificate authenticates with a client certificate (mutual TLS), e.g. loaded with tls.LoadX509KeyPair.
+// The certificate is added to the TLS configuration from WithTLSConfig, or to a copy of the one in the transport of the
+// http.Client if WithTLSConfig is not used. The transport must be an *http.Transport, calls fail if it is not.
+func WithClientCertificate(cert tls.Certificate) ClientOption {
+	return func(c *Client) {
+		c.clientCerts = append(c.clientCerts, cert)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"sync"
+)
+
+// DefaultReadConcurrency is the number of parallel reads made by ReadTree unless WithReadConcurrency is given
+const DefaultReadConcurrency = 8
+
+type treeEntry struct {
+	path  Path
+	value []byte
+}
+
+// ReadTree reads all keys below path, including path itself, and returns them in a map indexed by the full path
+// string. The keys are read in parallel from the commit that was HEAD when the call was made, so the result is
+// consistent even if the tree is updated.
+func (rest *Conn) ReadTree(path Path) (map[string][]byte, error) {
+	return rest.ReadTreeContext(context.Background(), path)
+}
+
+// ReadTreeContext is like ReadTree, but the requests are aborted if ctx is cancelled.
+func (rest *Conn) ReadTreeContext(ctx context.Context, path Path) (map[string][]byte, error) {
+	head, err := rest.HeadContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := rest.readTreeAt(ctx, head, path)
+	if err != nil {
+		return nil, err
+	}
+	return treeMap(entries), nil
+}
+
+// ReadTree reads all keys below path in the view, like Conn.ReadTree
+func (view *View) ReadTree(path Path) (map[string][]byte, error) {
+	return view.ReadTreeContext(view.context(), path)
+}
+
+// ReadTreeContext is like ReadTree, but the requests are aborted if ctx is cancelled.
+func (view *View) ReadTreeContext(ctx context.Context, path Path) (map[string][]byte, error) {
+	entries, err := readTree(ctx, view.IterContext, view.ReadContext, path, view.srv.readConcurrency)
+	if err != nil {
+		return nil, err
+	}
+	return treeMap(entries), nil
+}
+
+// readTreeAt reads all keys below path at a commit. A nil commit returns an empty tree.
+func (rest *Conn) readTreeAt(ctx context.Context, commit []byte, path Path) ([]treeEntry, error) {
+	if commit == nil {
+		return nil, nil
+	}
+	c := rest.FromTree(hex.EncodeToString(commit))
+	return readTree(ctx, c.IterContext, c.ReadContext, path, rest.readConcurrency)
+}
+
+// readTree lists the keys below path with iter and reads them with up to n parallel calls to read
+func readTree(ctx context.Context, iter func(context.Context) (<-chan *IterResult, error),
+	read func(context.Context, Path) ([]byte, error), path Path, n int) ([]treeEntry, error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // stop iter and reads on error
+
+	ch, err := iter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var entries []treeEntry
+	for r := range ch {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		if hasPathPrefix(r.Path, path) {
+			entries = append(entries, treeEntry{path: r.Path})
+		}
+	}
+
+	if n < 1 {
+		n = DefaultReadConcurrency
+	}
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	next := make(chan int)
+	for w := 0; w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				v, err := read(ctx, entries[i].path)
+				if err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					continue
+				}
+				entries[i].value = v
+			}
+		}()
+	}
+feed:
+	for i := range entries {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// hasPathPrefix returns true if p is equal to or below prefix
+func hasPathPrefix(p Path, prefix Path) bool {
+	if len(p) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if !bytes.Equal(p[i], prefix[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func treeMap(entries []treeEntry) map[string][]byte {
+	m := make(map[string][]byte, len(entries))
+	for _, e := range entries {
+		m[e.path.String()] = e.value
+	}
+	return m
+}
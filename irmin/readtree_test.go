@@ -0,0 +1,65 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/MagnusS/irmin-go/irmin"
+)
+
+func TestReadTree(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	r := srv.Conn("irmin-go-tester", irmin.WithReadConcurrency(3))
+	for i := 0; i < 50; i++ {
+		k := fmt.Sprintf("/read-tree/%d/key", i)
+		if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath(k), []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/not-read"), []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(m map[string][]byte, err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(m) != 50 {
+			t.Fatalf("expected 50 keys, got %d", len(m))
+		}
+		for k, v := range m {
+			if k != string(v) {
+				t.Fatalf("expected %s in %s, got %s", k, k, v)
+			}
+		}
+	}
+	check(r.ReadTree(irmin.ParsePath("/read-tree")))
+
+	view, err := r.CreateView(r.NewTask("create view"), irmin.Path{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(view.ReadTree(irmin.ParsePath("/read-tree")))
+
+	if m, err := r.ReadTree(irmin.ParsePath("/no-such-path")); err != nil || len(m) != 0 {
+		t.Fatalf("expected empty tree, got %v %v", m, err)
+	}
+}
@@ -0,0 +1,176 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ReplicaUpdate is sent by a Replica when changes from a commit have been applied
+type ReplicaUpdate struct {
+	Commit  []byte
+	Changes []DiffChange
+}
+
+// Replica is a local copy of the keys below a path. It is updated from a WatchPathResilient and keeps serving the
+// last known values while Irmin is unreachable.
+type Replica struct {
+	C <-chan *ReplicaUpdate // Applied changes. The replica is not updated further until they are received.
+
+	conn    *Conn
+	path    Path
+	watcher *Watcher
+	out     chan *ReplicaUpdate
+
+	mu     sync.RWMutex
+	data   map[string]treeEntry
+	commit []byte
+}
+
+// NewReplica reads all keys below path at the current HEAD and keeps them up to date until ctx is cancelled
+func (rest *Conn) NewReplica(ctx context.Context, path Path) (*Replica, error) {
+	head, err := rest.HeadContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := rest.readTreeAt(ctx, head, path)
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string]treeEntry, len(entries))
+	for _, e := range entries {
+		data[e.path.String()] = e
+	}
+	return rest.startReplica(ctx, path, head, data), nil
+}
+
+// startReplica starts a replica with data read at commit. A nil commit means the store was empty, and all keys that
+// exist when the watch starts are read.
+func (rest *Conn) startReplica(ctx context.Context, path Path, commit []byte, data map[string]treeEntry) *Replica {
+	r := &Replica{
+		conn:   rest,
+		path:   path,
+		out:    make(chan *ReplicaUpdate, 16),
+		data:   data,
+		commit: commit,
+	}
+	r.C = r.out
+	r.watcher = rest.watchPathResilient(ctx, path, commit, true, Backoff{})
+	go r.run(ctx)
+	return r
+}
+
+// Get returns the value of a key, or false if the key does not exist
+func (r *Replica) Get(path Path) ([]byte, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok := r.data[path.String()]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte{}, e.value...), true
+}
+
+// Snapshot returns a copy of all keys, indexed by path string, and the commit they were read at
+func (r *Replica) Snapshot() (map[string][]byte, []byte) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	m := make(map[string][]byte, len(r.data))
+	for k, e := range r.data {
+		m[k] = append([]byte{}, e.value...)
+	}
+	return m, r.commit
+}
+
+// Commit returns the commit the replica is at
+func (r *Replica) Commit() []byte {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.commit
+}
+
+// State returns the state of the watch used to update the replica
+func (r *Replica) State() WatchState {
+	return r.watcher.State()
+}
+
+func (r *Replica) run(ctx context.Context) {
+	defer close(r.out)
+	for wc := range r.watcher.C {
+		u, err := r.read(ctx, wc)
+		if err != nil { // cancelled
+			return
+		}
+		r.mu.Lock()
+		for _, c := range u.Changes {
+			if c.Change == KeyDeleted {
+				delete(r.data, c.Key.String())
+			} else {
+				r.data[c.Key.String()] = treeEntry{c.Key, append([]byte{}, c.New...)} // New is shared with C
+			}
+		}
+		r.commit = wc.Commit
+		r.mu.Unlock()
+
+		select {
+		case r.out <- u:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// read reads the new values in a commit. Reads are retried until they succeed or ctx is cancelled.
+func (r *Replica) read(ctx context.Context, wc *WatchPathCommit) (*ReplicaUpdate, error) {
+	at := r.conn.FromTree(hex.EncodeToString(wc.Commit))
+	u := &ReplicaUpdate{Commit: wc.Commit}
+	for _, c := range wc.Changes {
+		old, _ := r.Get(c.Key)
+		d := DiffChange{WatchPathChange: c, Old: old}
+		if c.Change != KeyDeleted {
+			for attempt := 0; ; attempt++ {
+				v, err := at.ReadContext(ctx, c.Key)
+				if err == nil {
+					d.New = v
+					break
+				}
+				if errors.Is(err, ErrNotFound) {
+					// at is pinned to the commit, so the server reported a change to a key that doesn't exist in its
+					// own tree. Follow the tree, as that is what a fresh replica would contain.
+					r.conn.logger.Log(LevelError, "irmin replica inconsistent watch reply, key not found at commit",
+						Field{"path", c.Key.String()}, Field{"change", c.Change}, Field{"commit", hex.EncodeToString(wc.Commit)})
+					d.Change = KeyDeleted
+					break
+				}
+				delay := DefaultBackoff.Delay(attempt)
+				r.conn.logger.Log(LevelWarn, "irmin replica read failed, retrying",
+					Field{"path", c.Key.String()}, Field{"retry", delay}, Field{"error", err})
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+		}
+		u.Changes = append(u.Changes, d)
+	}
+	return u, nil
+}
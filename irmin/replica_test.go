@@ -0,0 +1,236 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MagnusS/irmin-go/irmin"
+)
+
+func TestReplica(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	r := getConn(t, srv)
+	for _, k := range []string{"/replica/a", "/replica/b", "/other"} {
+		if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath(k), []byte("foo")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rep, err := r.NewReplica(ctx, irmin.ParsePath("/replica"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, commit := rep.Snapshot()
+	if len(m) != 2 || string(m["/replica/a"]) != "foo" || !bytes.Equal(commit, mustHead(t, r)) {
+		t.Fatalf("unexpected snapshot %v at %x", m, commit)
+	}
+	m["/replica/a"][0] = 'X'
+	if v, _ := rep.Get(irmin.ParsePath("/replica/a")); string(v) != "foo" {
+		t.Fatalf("replica changed through snapshot, got %s", v)
+	}
+	v, _ := rep.Get(irmin.ParsePath("/replica/a"))
+	v[0] = 'X'
+	if v, _ := rep.Get(irmin.ParsePath("/replica/a")); string(v) != "foo" {
+		t.Fatalf("replica changed through Get, got %s", v)
+	}
+
+	expect := func(change, key, value string) {
+		select {
+		case u := <-rep.C:
+			if len(u.Changes) != 1 || u.Changes[0].Change != change || u.Changes[0].Key.String() != key || string(u.Changes[0].New) != value {
+				t.Fatalf("expected %s %s=%s, got %v", change, key, value, u.Changes)
+			}
+			if !bytes.Equal(rep.Commit(), u.Commit) {
+				t.Fatal("replica commit not updated")
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatal("Timed out while waiting for replica update")
+		}
+	}
+
+	if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/replica/a"), []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	expect(irmin.KeyUpdated, "/replica/a", "bar")
+	if v, ok := rep.Get(irmin.ParsePath("/replica/a")); !ok || string(v) != "bar" {
+		t.Fatalf("expected bar, got %s", v)
+	}
+
+	if err := r.Remove(r.NewTask("remove key"), irmin.ParsePath("/replica/b")); err != nil {
+		t.Fatal(err)
+	}
+	expect(irmin.KeyDeleted, "/replica/b", "")
+	if _, ok := rep.Get(irmin.ParsePath("/replica/b")); ok {
+		t.Fatal("expected /replica/b to be removed")
+	}
+
+	// No updates are lost if C is read slowly
+	for i := 0; i < 40; i++ {
+		if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/replica/a"), []byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 40; i++ {
+		select {
+		case u := <-rep.C:
+			if len(u.Changes) != 1 || string(u.Changes[0].New) != fmt.Sprint(i) {
+				t.Fatalf("expected update %d, got %v", i, u.Changes)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatalf("Timed out while waiting for update %d", i)
+		}
+	}
+	if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/replica/a"), []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	expect(irmin.KeyUpdated, "/replica/a", "bar")
+
+	// Last known values are kept while disconnected
+	srv.CloseClientConnections()
+	if v, ok := rep.Get(irmin.ParsePath("/replica/a")); !ok || string(v) != "bar" {
+		t.Fatalf("expected bar while disconnected, got %s", v)
+	}
+}
+
+func TestReplicaSnapshot(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	r := getConn(t, srv)
+	path := irmin.ParsePath("/replica")
+	for _, k := range []string{"/replica/a", "/replica/b"} {
+		if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath(k), []byte("foo")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/replica/binary"), []byte{0xff, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "irmin-go-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "replica.json")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rep, err := r.OpenReplica(ctx, path, filename) // no snapshot yet
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rep.SaveSnapshot(filename); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	// Changes made while the replica is stopped
+	if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/replica/a"), []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := irmin.LoadSnapshot(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := s.Get(irmin.ParsePath("/replica/binary")); !ok || !bytes.Equal(v, []byte{0xff, 0x00}) {
+		t.Fatalf("unexpected binary value in snapshot %x", v)
+	}
+	if m := s.Values(); len(m) != 3 || string(m["/replica/a"]) != "foo" {
+		t.Fatalf("unexpected snapshot contents %v", m)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	rep, err = r.OpenReplica(ctx, path, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rep.Commit(), s.Commit) {
+		t.Fatal("replica should start at the snapshot commit")
+	}
+	select {
+	case u := <-rep.C: // only the delta is replayed
+		if len(u.Changes) != 1 || u.Changes[0].Key.String() != "/replica/a" || string(u.Changes[0].Old) != "foo" || string(u.Changes[0].New) != "bar" {
+			t.Fatalf("unexpected changes %v", u.Changes)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timed out while waiting for replica update")
+	}
+	if v, ok := rep.Get(irmin.ParsePath("/replica/b")); !ok || string(v) != "foo" {
+		t.Fatalf("expected foo from snapshot, got %s", v)
+	}
+}
+
+func TestReplicaEmptySnapshot(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	dir, err := ioutil.TempDir("", "irmin-go-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "replica.json")
+
+	r := getConn(t, srv)
+	path := irmin.ParsePath("/p")
+	ctx, cancel := context.WithCancel(context.Background())
+	rep, err := r.NewReplica(ctx, path) // the store is empty
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rep.SaveSnapshot(filename); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	// Written while the replica is stopped. There is no commit in the snapshot to resume from.
+	if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/p/k"), []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	rep, err = r.OpenReplica(ctx, path, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case u := <-rep.C:
+		if len(u.Changes) != 1 || u.Changes[0].Change != irmin.KeyCreated || string(u.Changes[0].New) != "foo" {
+			t.Fatalf("unexpected changes %v", u.Changes)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timed out while waiting for key written while the replica was stopped")
+	}
+	if v, ok := rep.Get(irmin.ParsePath("/p/k")); !ok || string(v) != "foo" {
+		t.Fatalf("expected foo, got %s %v", v, ok)
+	}
+}
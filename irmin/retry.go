@@ -0,0 +1,144 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// RetryPolicy configures how failed calls are retried, see WithRetry. Only calls that are safe to repeat are
+// retried: read-only calls, the start of streams and, if RetryGuardedWrites is set, writes that fail if they have
+// already been applied. Calls are retried on transport errors and on the HTTP status codes in RetryStatus.
+type RetryPolicy struct {
+	MaxAttempts        int     // Maximum number of attempts, including the first. 1 or less disables retries.
+	Backoff            Backoff // Delay between attempts
+	RetryStatus        []int   // HTTP status codes to retry. Defaults to 502, 503 and 504 if empty.
+	RetryGuardedWrites bool    // Also retry compare-and-set and compare-and-set-head
+}
+
+// DefaultRetryPolicy makes up to 4 attempts with the default backoff
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	Backoff:     DefaultBackoff,
+}
+
+// readCommands are the commands that don't modify the store
+var readCommands = map[string]bool{
+	"":          true, // list of commands
+	"list":      true,
+	"mem":       true,
+	"head":      true,
+	"read":      true,
+	"iter":      true,
+	"watch":     true,
+	"watch-rec": true,
+	"view/read": true,
+	"view/iter": true,
+	"view/list": true,
+	"view/mem":  true,
+	"branches":  true,
+	"commit":    true,
+	"lca":       true,
+}
+
+// guardedCommands are writes that fail instead of being applied twice
+var guardedCommands = map[string]bool{
+	"compare-and-set":      true,
+	"compare-and-set-head": true,
+}
+
+// attempts returns the maximum number of attempts for command
+func (p *RetryPolicy) attempts(command string) int {
+	if p == nil || p.MaxAttempts < 1 {
+		return 1
+	}
+	if readCommands[command] || (p.RetryGuardedWrites && guardedCommands[command]) {
+		return p.MaxAttempts
+	}
+	return 1
+}
+
+// retryable returns true if a request that returned res or err should be retried
+func (p *RetryPolicy) retryable(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	status := p.RetryStatus
+	if len(status) == 0 {
+		status = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+	}
+	for _, s := range status {
+		if res.StatusCode == s {
+			return true
+		}
+	}
+	return false
+}
+
+// send sends a request with the extra headers in header and retries it according to the retry policy. The attempts
+// are recorded in info. The caller must close the response body.
+func (c *Client) send(ctx context.Context, uri *url.URL, post *postRequest, header http.Header, info *RequestInfo) (*http.Response, error) {
+	if c.configErr != nil {
+		return nil, c.configErr
+	}
+	if post != nil {
+		j, err := json.Marshal(post)
+		if err != nil {
+			return nil, err
+		}
+		info.request = j
+	}
+	attempts := c.retry.attempts(info.Command)
+	for attempt := 1; ; attempt++ {
+		req, err := c.newRequest(ctx, uri, info.request, header)
+		if err != nil {
+			return nil, err
+		}
+		info.Attempts = attempt
+		if req.ContentLength > 0 {
+			info.BytesSent += req.ContentLength
+		}
+		res, err := c.do(req)
+		if res != nil {
+			info.Status = res.StatusCode
+		}
+		if attempt >= attempts || ctx.Err() != nil || !c.retry.retryable(res, err) {
+			return res, err
+		}
+		if res != nil {
+			res.Body.Close()
+		}
+
+		d := c.retry.Backoff.Delay(attempt - 1)
+		fields := []Field{{"command", info.Command}, {"path", info.path}, {"attempt", attempt}, {"retry", d}}
+		if err == nil {
+			fields = append(fields, Field{"status", res.StatusCode})
+		} else {
+			fields = append(fields, Field{"error", err})
+		}
+		c.logger.Log(LevelInfo, "irmin call failed, retrying", fields...)
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,159 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ReplicaSnapshot contains the keys of a Replica and the commit they were read at
+type ReplicaSnapshot struct {
+	Path   Path   // Path the replica was created from
+	Commit []byte // Commit the keys were read at
+	keys   map[string]treeEntry
+}
+
+const snapshotVersion = 1
+
+// snapshotFile is the JSON representation of a ReplicaSnapshot
+type snapshotFile struct {
+	Version int           `json:"version"`
+	Path    Path          `json:"path"`
+	Commit  string        `json:"commit"`
+	Keys    []snapshotKey `json:"keys"`
+}
+
+type snapshotKey struct {
+	Path  Path  `json:"path"`
+	Value Value `json:"value"`
+}
+
+// Get returns the value of a key in the snapshot, or false if the key does not exist
+func (s *ReplicaSnapshot) Get(path Path) ([]byte, bool) {
+	e, ok := s.keys[path.String()]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte{}, e.value...), true
+}
+
+// Values returns a copy of all keys in the snapshot, indexed by path string
+func (s *ReplicaSnapshot) Values() map[string][]byte {
+	m := make(map[string][]byte, len(s.keys))
+	for k, e := range s.keys {
+		m[k] = append([]byte{}, e.value...)
+	}
+	return m
+}
+
+// SaveSnapshot writes the current state of the replica to a file. The file is replaced atomically, so a crash while
+// writing leaves the previous snapshot intact.
+func (r *Replica) SaveSnapshot(filename string) error {
+	r.mu.RLock()
+	f := snapshotFile{
+		Version: snapshotVersion,
+		Path:    r.path,
+		Commit:  hex.EncodeToString(r.commit),
+	}
+	for _, e := range r.data {
+		f.Keys = append(f.Keys, snapshotKey{e.path, e.value})
+	}
+	r.mu.RUnlock()
+	sort.Slice(f.Keys, func(i, j int) bool { return f.Keys[i].Path.String() < f.Keys[j].Path.String() })
+
+	j, err := json.Marshal(&f)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails after rename
+	if _, err = tmp.Write(j); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// LoadSnapshot reads a snapshot written by Replica.SaveSnapshot
+func LoadSnapshot(filename string) (*ReplicaSnapshot, error) {
+	j, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var f snapshotFile
+	if err = json.Unmarshal(j, &f); err != nil {
+		return nil, fmt.Errorf("unable to parse snapshot %s: %w", filename, err)
+	}
+	if f.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d in %s", f.Version, filename)
+	}
+	s := &ReplicaSnapshot{Path: f.Path, keys: make(map[string]treeEntry, len(f.Keys))}
+	if f.Commit != "" {
+		if s.Commit, err = hex.DecodeString(f.Commit); err != nil {
+			return nil, fmt.Errorf("invalid commit in snapshot %s: %w", filename, err)
+		}
+	}
+	for _, k := range f.Keys {
+		s.keys[k.Path.String()] = treeEntry{k.Path, k.Value}
+	}
+	return s, nil
+}
+
+// ResumeReplica starts a replica from a snapshot without contacting Irmin. The watch is resumed from the commit in the
+// snapshot, so only the changes made since the snapshot was taken are read.
+func (rest *Conn) ResumeReplica(ctx context.Context, s *ReplicaSnapshot) *Replica {
+	data := make(map[string]treeEntry, len(s.keys))
+	for k, e := range s.keys {
+		data[k] = e
+	}
+	return rest.startReplica(ctx, s.Path, s.Commit, data)
+}
+
+// OpenReplica resumes a replica from the snapshot in filename if it exists and was taken at path, otherwise a new
+// replica is created with NewReplica
+func (rest *Conn) OpenReplica(ctx context.Context, path Path, filename string) (*Replica, error) {
+	s, err := LoadSnapshot(filename)
+	if os.IsNotExist(err) {
+		return rest.NewReplica(ctx, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.Path.String() != path.String() {
+		rest.logger.Log(LevelWarn, "irmin snapshot is for another path, ignoring",
+			Field{"file", filename}, Field{"snapshot_path", s.Path.String()}, Field{"path", path.String()})
+		return rest.NewReplica(ctx, path)
+	}
+	return rest.ResumeReplica(ctx, s), nil
+}
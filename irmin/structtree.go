@@ -0,0 +1,351 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WriteStruct stores a struct as a tree of keys below path, one key per field. Nested structs, pointers to structs
+// and maps with string keys are stored as subtrees. The key of a field is its name, or the name given in an
+// `irmin:"name"` tag. Fields tagged with `irmin:"-"` and unexported fields are skipped. Strings, []byte, numbers and
+// bools are stored as text, types implementing ValueMarshaler or encoding.TextMarshaler encode themselves and other
+// values are encoded with the codec set with WithCodec.
+//
+// The tree is written in a single transaction. Only keys that have changed are updated and keys below path that are
+// not part of v are removed, so every field can be watched individually with WatchPath.
+func (rest *Conn) WriteStruct(t Task, path Path, v interface{}) error {
+	return rest.WriteStructContext(context.Background(), t, path, v)
+}
+
+// WriteStructContext is like WriteStruct, but the requests are aborted if ctx is cancelled.
+func (rest *Conn) WriteStructContext(ctx context.Context, t Task, path Path, v interface{}) error {
+	var entries []treeEntry
+	if err := marshalTree(reflect.ValueOf(v), Path{}, rest.codec(), &entries); err != nil {
+		return err
+	}
+	return rest.TransactionContext(ctx, t, path, func(tx *View) error {
+		cur, err := tx.ReadTreeContext(ctx, Path{})
+		if err != nil {
+			return err
+		}
+		keep := make(map[string]bool, len(entries))
+		for _, e := range entries {
+			k := e.path.String()
+			keep[k] = true
+			if old, ok := cur[k]; ok && bytes.Equal(old, e.value) {
+				continue
+			}
+			if _, err := tx.UpdateContext(ctx, t, e.path, e.value); err != nil {
+				return err
+			}
+		}
+		for k := range cur {
+			if !keep[k] {
+				if err := tx.RemoveContext(ctx, t, ParsePath(k)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// ReadStruct reads a tree of keys stored with WriteStruct into the struct pointed to by v. Keys that don't exist
+// leave the field unchanged. A *DecodeError is returned if a value could not be decoded.
+func (rest *Conn) ReadStruct(path Path, v interface{}) error {
+	return rest.ReadStructContext(context.Background(), path, v)
+}
+
+// ReadStructContext is like ReadStruct, but the requests are aborted if ctx is cancelled.
+func (rest *Conn) ReadStructContext(ctx context.Context, path Path, v interface{}) error {
+	m, err := rest.ReadTreeContext(ctx, path)
+	if err != nil {
+		return err
+	}
+	return unmarshalTree(v, path, m, rest.codec())
+}
+
+// ReadStruct reads a tree of keys into a struct, like Conn.ReadStruct
+func (view *View) ReadStruct(path Path, v interface{}) error {
+	return view.ReadStructContext(view.context(), path, v)
+}
+
+// ReadStructContext is like ReadStruct, but the requests are aborted if ctx is cancelled.
+func (view *View) ReadStructContext(ctx context.Context, path Path, v interface{}) error {
+	m, err := view.ReadTreeContext(ctx, path)
+	if err != nil {
+		return err
+	}
+	return unmarshalTree(v, path, m, view.codec())
+}
+
+var (
+	valueMarshalerType   = reflect.TypeOf((*ValueMarshaler)(nil)).Elem()
+	valueUnmarshalerType = reflect.TypeOf((*ValueUnmarshaler)(nil)).Elem()
+	textMarshalerType    = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType  = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// fieldName returns the key of a struct field, or "" if the field is skipped
+func fieldName(f reflect.StructField) string {
+	if f.PkgPath != "" { // unexported
+		return ""
+	}
+	name := f.Name
+	if tag, ok := f.Tag.Lookup("irmin"); ok {
+		if tag == "-" {
+			return ""
+		}
+		if tag != "" {
+			name = tag
+		}
+	}
+	return name
+}
+
+// subtree returns true if values of type t are stored as a subtree
+func subtree(t reflect.Type) bool {
+	for _, i := range []reflect.Type{valueMarshalerType, valueUnmarshalerType, textMarshalerType, textUnmarshalerType} {
+		if t.Implements(i) || reflect.PtrTo(t).Implements(i) {
+			return false
+		}
+	}
+	return t.Kind() == reflect.Struct || (t.Kind() == reflect.Map && t.Key().Kind() == reflect.String)
+}
+
+func appendPath(path Path, name string) Path {
+	return append(append(Path{}, path...), NewValue(name))
+}
+
+// marshalTree adds the keys in v to entries
+func marshalTree(v reflect.Value, path Path, codec Codec, entries *[]treeEntry) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		if v.Kind() == reflect.Ptr && !subtree(v.Elem().Type()) {
+			break // leaf with pointer receiver
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil
+	}
+
+	switch {
+	case subtree(v.Type()) && v.Kind() == reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if name := fieldName(v.Type().Field(i)); name != "" {
+				if err := marshalTree(v.Field(i), appendPath(path, name), codec, entries); err != nil {
+					return err
+				}
+			}
+		}
+	case subtree(v.Type()):
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, k := range keys {
+			if strings.ContainsRune(k.String(), '/') {
+				return fmt.Errorf("unable to encode %s: map key %q contains /", path.String(), k.String())
+			}
+			if err := marshalTree(v.MapIndex(k), appendPath(path, k.String()), codec, entries); err != nil {
+				return err
+			}
+		}
+	default:
+		if len(path) == 0 {
+			return fmt.Errorf("unable to encode %s as a tree, expected struct or map", v.Type())
+		}
+		b, err := encodeLeaf(v, codec)
+		if err != nil {
+			return fmt.Errorf("unable to encode %s: %w", path.String(), err)
+		}
+		*entries = append(*entries, treeEntry{path, b})
+	}
+	return nil
+}
+
+func encodeLeaf(v reflect.Value, codec Codec) ([]byte, error) {
+	i := v.Interface()
+	if v.CanAddr() {
+		i = v.Addr().Interface() // include methods with pointer receivers
+	}
+	if m, ok := i.(ValueMarshaler); ok {
+		return m.MarshalValue()
+	}
+	if m, ok := i.(encoding.TextMarshaler); ok {
+		return m.MarshalText()
+	}
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return codec.Marshal(nil)
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return []byte(v.String()), nil
+	case reflect.Bool:
+		return []byte(strconv.FormatBool(v.Bool())), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return []byte(strconv.FormatInt(v.Int(), 10)), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return []byte(strconv.FormatUint(v.Uint(), 10)), nil
+	case reflect.Float32, reflect.Float64:
+		return []byte(strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits())), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return append([]byte{}, v.Bytes()...), nil
+		}
+	}
+	return codec.Marshal(v.Interface())
+}
+
+// unmarshalTree decodes the keys in m, indexed by path string, into the value pointed to by v
+func unmarshalTree(v interface{}, path Path, m map[string][]byte, codec Codec) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("unable to decode %s into %T, expected non-nil pointer", path.String(), v)
+	}
+	_, err := decodeTree(rv.Elem(), path, m, codec)
+	return err
+}
+
+// decodeTree decodes the keys at or below path into v. Returns false if there were no keys.
+func decodeTree(v reflect.Value, path Path, m map[string][]byte, codec Codec) (bool, error) {
+	if v.Kind() == reflect.Ptr {
+		if !hasKeys(path, m) {
+			return false, nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeTree(v.Elem(), path, m, codec)
+	}
+
+	found := false
+	switch {
+	case subtree(v.Type()) && v.Kind() == reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if name := fieldName(v.Type().Field(i)); name != "" {
+				ok, err := decodeTree(v.Field(i), appendPath(path, name), m, codec)
+				if err != nil {
+					return false, err
+				}
+				found = found || ok
+			}
+		}
+	case subtree(v.Type()) && v.Kind() == reflect.Map:
+		for _, name := range childNames(path, m) {
+			e := reflect.New(v.Type().Elem()).Elem()
+			ok, err := decodeTree(e, appendPath(path, name), m, codec)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				if v.IsNil() {
+					v.Set(reflect.MakeMap(v.Type()))
+				}
+				v.SetMapIndex(reflect.ValueOf(name).Convert(v.Type().Key()), e)
+				found = true
+			}
+		}
+	default:
+		b, ok := m[path.String()]
+		if !ok {
+			return false, nil
+		}
+		if err := decodeLeaf(v, b, codec); err != nil {
+			return false, &DecodeError{path, err}
+		}
+		found = true
+	}
+	return found, nil
+}
+
+func decodeLeaf(v reflect.Value, b []byte, codec Codec) error {
+	if u, ok := v.Addr().Interface().(ValueUnmarshaler); ok {
+		return u.UnmarshalValue(b)
+	}
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText(b)
+	}
+	s := string(b)
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+		return nil
+	case reflect.Bool:
+		x, err := strconv.ParseBool(s)
+		v.SetBool(x)
+		return err
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		v.SetInt(x)
+		return err
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		x, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		v.SetUint(x)
+		return err
+	case reflect.Float32, reflect.Float64:
+		x, err := strconv.ParseFloat(s, v.Type().Bits())
+		v.SetFloat(x)
+		return err
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes(append([]byte{}, b...))
+			return nil
+		}
+	}
+	return codec.Unmarshal(b, v.Addr().Interface())
+}
+
+// hasKeys returns true if m contains path or keys below path
+func hasKeys(path Path, m map[string][]byte) bool {
+	p := path.String()
+	for k := range m {
+		if k == p || strings.HasPrefix(k, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// childNames returns the sorted names of the keys and subtrees directly below path
+func childNames(path Path, m map[string][]byte) []string {
+	prefix := path.String() + "/"
+	names := make(map[string]bool)
+	for k := range m {
+		if strings.HasPrefix(k, prefix) {
+			names[strings.SplitN(k[len(prefix):], "/", 2)[0]] = true
+		}
+	}
+	res := make([]string, 0, len(names))
+	for n := range names {
+		res = append(res, n)
+	}
+	sort.Strings(res)
+	return res
+}
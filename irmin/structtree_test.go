@@ -0,0 +1,123 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin_test
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/MagnusS/irmin-go/irmin"
+)
+
+type backend struct {
+	Host   string `irmin:"host"`
+	Weight float64
+}
+
+type service struct {
+	Name     string             `irmin:"name"`
+	Port     int                `irmin:"port"`
+	Enabled  bool               `irmin:"enabled"`
+	Tags     []string           `irmin:"tags"`
+	Updated  time.Time          `irmin:"updated"`
+	Primary  *backend           `irmin:"primary"`
+	Backends map[string]backend `irmin:"backends"`
+	Secret   string             `irmin:"-"`
+	internal int
+}
+
+func TestStructTree(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	r := getConn(t, srv)
+	path := irmin.ParsePath("/services/web")
+	in := service{
+		Name:    "web",
+		Port:    80,
+		Enabled: true,
+		Tags:    []string{"a", "b"},
+		Updated: time.Date(2015, 10, 1, 12, 0, 0, 0, time.UTC),
+		Primary: &backend{"10.0.0.1", 1},
+		Backends: map[string]backend{
+			"a": {"10.0.0.2", 0.5},
+			"b": {"10.0.0.3", 0.5},
+		},
+		Secret: "not stored",
+	}
+	if err := r.WriteStruct(r.NewTask("write struct"), path, &in); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := r.ReadString(irmin.ParsePath("/services/web/backends/a/host")); err != nil || v != "10.0.0.2" {
+		t.Fatalf("expected host to be stored as a key, got %s (err=%v)", v, err)
+	}
+	if b, err := r.Mem(irmin.ParsePath("/services/web/Secret")); err != nil || b {
+		t.Fatalf("skipped field should not be stored (mem=%t, err=%v)", b, err)
+	}
+
+	var out service
+	if err := r.ReadStruct(path, &out); err != nil {
+		t.Fatal(err)
+	}
+	in.Secret = ""
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("expected %+v, got %+v", in, out)
+	}
+
+	// Only changed keys should be updated
+	ch, err := r.WatchPath(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in.Port = 8080
+	delete(in.Backends, "b")
+	if err := r.WriteStruct(r.NewTask("write struct"), path, in); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case c := <-ch:
+		if c.Error != nil {
+			t.Fatal(c.Error)
+		}
+		var changes []string
+		for _, change := range c.Changes {
+			changes = append(changes, change.Change+change.Key.String())
+		}
+		sort.Strings(changes)
+		expect := []string{"*/services/web/port", "-/services/web/backends/b/Weight", "-/services/web/backends/b/host"}
+		if !reflect.DeepEqual(changes, expect) {
+			t.Fatalf("expected changes %v, got %v", expect, changes)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timed out while waiting for WatchPath result")
+	}
+
+	view, err := r.CreateView(r.NewTask("create view"), irmin.ParsePath("/services"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = service{}
+	if err := view.ReadStruct(irmin.ParsePath("/web"), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("expected %+v in view, got %+v", in, out)
+	}
+}
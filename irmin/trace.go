@@ -0,0 +1,176 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Attribute is a key/value pair added to a Span
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// Span is an operation started by a Tracer
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	End(err error) // err is nil if the operation succeeded
+}
+
+// Tracer starts spans for calls, streams and transactions, see WithTracer. Spans are named irmin.<command>, e.g.
+// irmin.read or irmin.view/update, and irmin.transaction, and have these attributes when known: irmin.command,
+// irmin.path, irmin.tree, irmin.commit, irmin.attempts and http.status_code.
+type Tracer interface {
+	// Start starts a span as a child of the span in ctx, if any, and returns a context containing the new span
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+	// Inject adds the trace headers for the span in ctx to an outgoing request
+	Inject(ctx context.Context, header http.Header)
+}
+
+// IgnoreTracer is an implementation of Tracer that does not trace
+type IgnoreTracer struct{}
+
+// Start returns ctx and a span that does nothing
+func (IgnoreTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	return ctx, ignoreSpan{}
+}
+
+// Inject does nothing
+func (IgnoreTracer) Inject(ctx context.Context, header http.Header) {}
+
+type ignoreSpan struct{}
+
+func (ignoreSpan) SetAttributes(attrs ...Attribute) {}
+func (ignoreSpan) End(err error)                    {}
+
+// commitCommands are the commands that return a commit hash
+var commitCommands = map[string]bool{
+	"head":            true,
+	"update":          true,
+	"remove":          true,
+	"remove-rec":      true,
+	"compare-and-set": true,
+}
+
+// splitCallURL returns the tree, command and path in a URL created by MakeCallURL. The tree/<name>/ prefix is removed
+// from the command and view commands are returned as view/<command>, without the node. The segments are unescaped
+// after splitting, so tree names and keys may contain "/". If the URL can't be unescaped, command is empty and path
+// is nil.
+func splitCallURL(uri *url.URL) (tree, command string, path Path) {
+	var s []string
+	for _, seg := range strings.Split(strings.TrimPrefix(uri.EscapedPath(), "/"), "/") {
+		u, err := url.PathUnescape(seg)
+		if err != nil {
+			return "", "", nil
+		}
+		s = append(s, u)
+	}
+	if len(s) >= 2 && s[0] == "tree" {
+		tree, s = s[1], s[2:]
+	}
+	if len(s) >= 3 && s[0] == "view" {
+		if s[1] == "create" {
+			command = "view/create"
+		} else {
+			command = "view/" + s[2]
+		}
+		return tree, command, segmentsPath(s[3:])
+	}
+	if len(s) == 0 {
+		return tree, "", Path{}
+	}
+	return tree, s[0], segmentsPath(s[1:])
+}
+
+// segmentsPath returns the Path with the unescaped segments s
+func segmentsPath(s []string) Path {
+	p := Path{}
+	for _, seg := range s {
+		if seg != "" {
+			p = append(p, Value(seg))
+		}
+	}
+	return p
+}
+
+// pathString returns p as a string, or "/" if it is empty
+func pathString(p Path) string {
+	if len(p) == 0 {
+		return "/"
+	}
+	return p.String()
+}
+
+// startSpan starts a span for a call to uri
+func (c *Client) startSpan(ctx context.Context, uri *url.URL) (context.Context, Span) {
+	tree, command, path := splitCallURL(uri)
+	attrs := []Attribute{{"irmin.command", command}, {"irmin.path", pathString(path)}}
+	if tree != "" {
+		attrs = append(attrs, Attribute{"irmin.tree", tree})
+	}
+	return c.tracer.Start(ctx, "irmin."+command, attrs...)
+}
+
+// finishSpan adds the status, attempts and commit to a span started with startSpan and ends it
+func finishSpan(span Span, info RequestInfo, commit string, err error) {
+	var attrs []Attribute
+	if info.Status != 0 {
+		attrs = append(attrs, Attribute{"http.status_code", strconv.Itoa(info.Status)})
+	}
+	if info.Attempts > 1 {
+		attrs = append(attrs, Attribute{"irmin.attempts", strconv.Itoa(info.Attempts)})
+	}
+	if commit != "" {
+		attrs = append(attrs, Attribute{"irmin.commit", commit})
+	}
+	if len(attrs) > 0 {
+		span.SetAttributes(attrs...)
+	}
+	span.End(err)
+}
+
+// replyCommit returns the commit hash in a reply to command, or "" if the command doesn't return a commit
+func replyCommit(command string, body []byte) string {
+	if !commitCommands[command] {
+		return ""
+	}
+	var reply struct {
+		Result json.RawMessage
+	}
+	if json.Unmarshal(body, &reply) != nil {
+		return ""
+	}
+	var hash Value
+	if json.Unmarshal(reply.Result, &hash) != nil {
+		var hashes []Value // head returns a list
+		if json.Unmarshal(reply.Result, &hashes) != nil || len(hashes) != 1 {
+			return ""
+		}
+		hash = hashes[0]
+	}
+	if _, err := hex.DecodeString(hash.String()); err != nil {
+		return ""
+	}
+	return hash.String()
+}
@@ -0,0 +1,172 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin_test
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/MagnusS/irmin-go/irmin"
+)
+
+type testSpan struct {
+	id     int
+	name   string
+	parent int // 0 if root
+	attrs  map[string]string
+	ended  bool
+	err    error
+}
+
+func (s *testSpan) SetAttributes(attrs ...irmin.Attribute) {
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *testSpan) End(err error) {
+	s.ended = true
+	s.err = err
+}
+
+type spanKey struct{}
+
+// testTracer records all spans and sends the span id in the X-Span-Id header
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(ctx context.Context, name string, attrs ...irmin.Attribute) (context.Context, irmin.Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := &testSpan{id: len(t.spans) + 1, name: name, attrs: make(map[string]string)}
+	if p, ok := ctx.Value(spanKey{}).(*testSpan); ok {
+		s.parent = p.id
+	}
+	s.SetAttributes(attrs...)
+	t.spans = append(t.spans, s)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (t *testTracer) Inject(ctx context.Context, header http.Header) {
+	if s, ok := ctx.Value(spanKey{}).(*testSpan); ok {
+		header.Set("X-Span-Id", strconv.Itoa(s.id))
+	}
+}
+
+// find returns the spans with name
+func (t *testTracer) find(name string) []*testSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var r []*testSpan
+	for _, s := range t.spans {
+		if s.name == name {
+			r = append(r, s)
+		}
+	}
+	return r
+}
+
+type headerTransport struct {
+	mu      sync.Mutex
+	spanIDs []string
+}
+
+func (ht *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ht.mu.Lock()
+	ht.spanIDs = append(ht.spanIDs, req.Header.Get("X-Span-Id"))
+	ht.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestTracer(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	tracer := new(testTracer)
+	ht := new(headerTransport)
+	r := srv.Conn("irmin-go-tester", irmin.WithTracer(tracer), irmin.WithHTTPClient(&http.Client{Transport: ht}))
+
+	hash, err := r.Update(r.NewTask("update"), irmin.ParsePath("/a/b"), []byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.FromTree("master").Read(irmin.ParsePath("/a/b")); err != nil {
+		t.Fatal(err)
+	}
+	err = r.Transaction(r.NewTask("tx"), irmin.ParsePath("/a"), func(tx *irmin.View) error {
+		if _, err := tx.Update(r.NewTask("update c"), irmin.ParsePath("c"), []byte("bar")); err != nil {
+			return err
+		}
+		if _, err := irmin.Put(tx, r.NewTask("put d"), irmin.ParsePath("d"), "baz"); err != nil {
+			return err
+		}
+		_, err := irmin.Get[string](tx, irmin.ParsePath("d"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err := r.Iter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range ch {
+	}
+
+	update := tracer.find("irmin.update")
+	if len(update) != 1 || update[0].attrs["irmin.path"] != "/a/b" || update[0].attrs["irmin.commit"] != hash ||
+		update[0].attrs["http.status_code"] != "200" || !update[0].ended || update[0].err != nil {
+		t.Errorf("unexpected update span %+v", update)
+	}
+	read := tracer.find("irmin.read")
+	if len(read) != 1 || read[0].attrs["irmin.tree"] != "master" || read[0].attrs["irmin.command"] != "read" {
+		t.Errorf("unexpected read span %+v", read)
+	}
+	iter := tracer.find("irmin.iter")
+	if len(iter) != 1 || !iter[0].ended {
+		t.Errorf("unexpected iter span %+v", iter)
+	}
+
+	tx := tracer.find("irmin.transaction")
+	if len(tx) != 1 || tx[0].parent != 0 || tx[0].attrs["irmin.path"] != "/a" || tx[0].attrs["irmin.attempts"] != "1" || !tx[0].ended {
+		t.Fatalf("unexpected transaction span %+v", tx)
+	}
+	for name, n := range map[string]int{"irmin.view/create": 1, "irmin.view/update": 2, "irmin.view/read": 1, "irmin.view/merge-path": 1} {
+		s := tracer.find(name)
+		if len(s) != n {
+			t.Errorf("expected %d %s spans, got %+v", n, name, s)
+		}
+		for _, s := range s {
+			if s.parent != tx[0].id {
+				t.Errorf("expected %s span in transaction, got %+v", name, s)
+			}
+		}
+	}
+
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+	for i, id := range ht.spanIDs {
+		if id == "" {
+			t.Errorf("request %d sent without trace header", i)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin
+
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+// Transaction runs fn in a new view created at path and merges the view into the current tree when fn returns nil.
+// If fn returns an error the view is discarded and the error is returned. If the merge fails with a conflict, the
+// transaction is retried with a new view up to the number of times set with SetTransactionRetries, and the
+// *ConflictError is returned when there are no retries left. fn may be called more than once and should not have
+// side effects outside the view.
+func (rest *Conn) Transaction(t Task, path Path, fn func(tx *View) error) error {
+	return rest.TransactionContext(context.Background(), t, path, fn)
+}
+
+// TransactionContext is like Transaction, but the requests made by Transaction are aborted if ctx is cancelled. Use
+// the Context variants of the View methods in fn to also cancel the requests made by fn.
+//
+// The transaction is traced as one irmin.transaction span. The calls made by the View methods without a context
+// argument are traced as children of this span.
+func (rest *Conn) TransactionContext(ctx context.Context, t Task, path Path, fn func(tx *View) error) (err error) {
+	ctx, span := rest.tracer.Start(ctx, "irmin.transaction",
+		Attribute{"irmin.path", path.String()}, Attribute{"irmin.tree", rest.treeName()})
+	attempt := 0
+	defer func() {
+		span.SetAttributes(Attribute{"irmin.attempts", strconv.Itoa(attempt + 1)})
+		span.End(err)
+	}()
+
+	for ; ; attempt++ {
+		tx, err := rest.CreateViewContext(ctx, t, path)
+		if err != nil {
+			return err
+		}
+		if err = fn(tx); err != nil {
+			return err
+		}
+		err = tx.MergePathContext(ctx, t, rest.treeName(), path)
+		if err == nil || !errors.Is(err, ErrConflict) || attempt >= rest.txRetries {
+			return err
+		}
+		rest.logger.Log(LevelInfo, "irmin transaction conflict, retrying",
+			Field{"path", path.String()}, Field{"tree", rest.treeName()}, Field{"attempt", attempt + 1}, Field{"error", err})
+	}
+}
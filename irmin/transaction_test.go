@@ -0,0 +1,96 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/MagnusS/irmin-go/irmin"
+)
+
+func TestTransaction(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	r := getConn(t, srv)
+	path := irmin.ParsePath("/tx")
+	if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/tx/a"), []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(key, value string) {
+		if v, err := r.ReadString(irmin.ParsePath(key)); err != nil || v != value {
+			t.Fatalf("expected %s=%s, got %s %v", key, value, v, err)
+		}
+	}
+
+	// Commit
+	err := r.Transaction(r.NewTask("transaction"), path, func(tx *irmin.View) error {
+		for _, k := range []string{"a", "b"} {
+			if _, err := tx.Update(r.NewTask("update key"), irmin.ParsePath(k), []byte("bar")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect("/tx/a", "bar")
+	expect("/tx/b", "bar")
+
+	// Discard
+	failed := errors.New("failed")
+	err = r.Transaction(r.NewTask("transaction"), path, func(tx *irmin.View) error {
+		if _, err := tx.Update(r.NewTask("update key"), irmin.ParsePath("a"), []byte("baz")); err != nil {
+			return err
+		}
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("expected error from function, got %v", err)
+	}
+	expect("/tx/a", "bar")
+
+	// Conflict, the key is updated outside the transaction on the first attempt
+	calls, outside := 0, "other"
+	conflicting := func(tx *irmin.View) error {
+		calls++
+		if calls == 1 {
+			if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/tx/a"), []byte(outside)); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Update(r.NewTask("update key"), irmin.ParsePath("a"), []byte("tx"))
+		return err
+	}
+	if err := r.Transaction(r.NewTask("transaction"), path, conflicting); !errors.Is(err, irmin.ErrConflict) {
+		t.Fatalf("expected ErrConflict without retries, got %v", err)
+	}
+	expect("/tx/a", "other")
+
+	calls, outside = 0, "other again"
+	r.SetTransactionRetries(2)
+	if err := r.Transaction(r.NewTask("transaction"), path, conflicting); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 attempts, got %d", calls)
+	}
+	expect("/tx/a", "tx")
+}
@@ -17,7 +17,7 @@
 package irmin
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"net/url"
 	"strings"
@@ -30,15 +30,28 @@ type View struct {
 	head string
 	node string
 	path Path
+	ctx  context.Context // Context the view was created with, without cancellation. Used as parent for spans.
 }
 
 type createViewReply stringReply
-type viewReadReply stringReply
-type viewMergeReply stringReply
+type viewMergeReply mergeReply
+
+type viewReadReply struct {
+	ErrorVersion
+	Result *Value // nil if the key does not exist
+}
 type viewUpdateReply updateReply
+type viewRemoveReply updateReply
+type viewListReply listReply
+type viewMemReply memReply
 
 // CreateView creates a new view (transaction) in Irmin relative to the given path
 func (rest *Conn) CreateView(t Task, path Path) (*View, error) {
+	return rest.CreateViewContext(context.Background(), t, path)
+}
+
+// CreateViewContext is like CreateView, but the request is aborted if ctx is cancelled.
+func (rest *Conn) CreateViewContext(ctx context.Context, t Task, path Path) (*View, error) {
 
 	var data createViewReply
 
@@ -51,20 +64,31 @@ func (rest *Conn) CreateView(t Task, path Path) (*View, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = rest.Call(uri, &body, &data)
+	err = rest.CallContext(ctx, uri, &body, &data)
 	if err != nil {
 		return nil, err
 	}
-	if data.Error.String() != "" {
-		return nil, fmt.Errorf(data.Error.String())
+	if err = data.err(); err != nil {
+		return nil, err
 	}
 	if data.Result.String() == "" {
-		return nil, fmt.Errorf("empty result")
+		return nil, &ProtocolError{Msg: "create view returned empty result"}
 	}
+	v, err := rest.OpenView(data.Result.String(), path)
+	if err != nil {
+		return nil, err
+	}
+	v.ctx = context.WithoutCancel(ctx)
+	return v, nil
+}
+
+// OpenView returns a view from an ID returned by View.ID, e.g. to continue a transaction in another process. path must
+// be the path the view was created from.
+func (rest *Conn) OpenView(id string, path Path) (*View, error) {
 	// TODO Simplify parsing if https://github.com/mirage/irmin/issues/295 is fixed
-	r := strings.Split(data.Result.String(), "-") // Just basic error checking here, hashes not checked for errors
+	r := strings.Split(id, "-") // Just basic error checking here, hashes not checked for errors
 	if len(r) != 2 {
-		return nil, fmt.Errorf("invalid result: %s", data.Result.String())
+		return nil, &ProtocolError{Msg: fmt.Sprintf("invalid view: %s", id)}
 	}
 
 	v := new(View)
@@ -75,6 +99,20 @@ func (rest *Conn) CreateView(t Task, path Path) (*View, error) {
 	return v, nil
 }
 
+// ID returns the current position of the view. The ID changes when the view is updated.
+func (view *View) ID() string {
+	return view.head + "-" + view.node
+}
+
+// context returns the context used by the methods without a context argument. It contains the values, such as the
+// trace span, of the context the view was created with, so the calls are traced as a part of the same operation.
+func (view *View) context() context.Context {
+	if view.ctx == nil {
+		return context.Background()
+	}
+	return view.ctx
+}
+
 // Path returns the original path the view was created from
 func (view *View) Path() Path {
 	return view.path
@@ -87,6 +125,11 @@ func (view *View) Tree() string {
 
 // Read a value from a view
 func (view *View) Read(path Path) ([]byte, error) {
+	return view.ReadContext(view.context(), path)
+}
+
+// ReadContext is like Read, but the request is aborted if ctx is cancelled.
+func (view *View) ReadContext(ctx context.Context, path Path) ([]byte, error) {
 	var data viewReadReply
 	var err error
 	cmd := fmt.Sprintf("view/%s/read", url.QueryEscape(view.node))
@@ -94,19 +137,27 @@ func (view *View) Read(path Path) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	if err = view.srv.Call(uri, nil, &data); err != nil {
+	if err = view.srv.CallContext(ctx, uri, nil, &data); err != nil {
 		return []byte{}, err
 	}
-	if data.Error.String() != "" {
-		return []byte{}, fmt.Errorf(data.Error.String())
+	if err = data.err(); err != nil {
+		return []byte{}, err
 	}
-	return data.Result, nil
+	if data.Result == nil {
+		return []byte{}, fmt.Errorf("read %s: %w", path.String(), ErrNotFound)
+	}
+	return *data.Result, nil
 }
 
 // ReadString reads a value and converts it into a string. If the value is not valid utf8 an error is returned.
 func (view *View) ReadString(path Path) (string, error) {
+	return view.ReadStringContext(view.context(), path)
+}
+
+// ReadStringContext is like ReadString, but the request is aborted if ctx is cancelled.
+func (view *View) ReadStringContext(ctx context.Context, path Path) (string, error) {
 	// TODO This code duplicates functionality from rest.ReadString
-	res, err := view.Read(path)
+	res, err := view.ReadContext(ctx, path)
 	if err != nil {
 		return "", err
 	}
@@ -118,6 +169,11 @@ func (view *View) ReadString(path Path) (string, error) {
 
 // Update a key. Returns hash as string on success.
 func (view *View) Update(t Task, path Path, contents []byte) (string, error) {
+	return view.UpdateContext(view.context(), t, path, contents)
+}
+
+// UpdateContext is like Update, but the request is aborted if ctx is cancelled.
+func (view *View) UpdateContext(ctx context.Context, t Task, path Path, contents []byte) (string, error) {
 	var data viewUpdateReply
 	var err error
 
@@ -136,14 +192,14 @@ func (view *View) Update(t Task, path Path, contents []byte) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	if err = view.srv.Call(uri, &body, &data); err != nil {
+	if err = view.srv.CallContext(ctx, uri, &body, &data); err != nil {
 		return data.Result.String(), err
 	}
-	if data.Error.String() != "" {
-		return "", fmt.Errorf(data.Error.String())
+	if err = data.err(); err != nil {
+		return "", err
 	}
 	if data.Result.String() == "" {
-		return "", fmt.Errorf("update seemed to succeed, but didn't return a hash", path.String(), data.Result.String())
+		return "", &ProtocolError{Msg: fmt.Sprintf("update %s seemed to succeed, but didn't return a hash", path.String())}
 	}
 
 	view.node = data.Result.String() // Store new node position
@@ -152,7 +208,13 @@ func (view *View) Update(t Task, path Path, contents []byte) (string, error) {
 }
 
 // MergePath will attempt to merge view into the specified branch and path. An empty tree value defaults to master.
+// If the merge fails because of a conflict a *ConflictError is returned.
 func (view *View) MergePath(t Task, tree string, path Path) error {
+	return view.MergePathContext(view.context(), t, tree, path)
+}
+
+// MergePathContext is like MergePath, but the request is aborted if ctx is cancelled.
+func (view *View) MergePathContext(ctx context.Context, t Task, tree string, path Path) error {
 	var data viewMergeReply
 	var err error
 
@@ -171,19 +233,23 @@ func (view *View) MergePath(t Task, tree string, path Path) error {
 	if err != nil {
 		return err
 	}
-	if err = view.srv.Call(uri, &body, &data); err != nil {
+	if err = view.srv.CallContext(ctx, uri, &body, &data); err != nil {
 		return err
 	}
-	if data.Error.String() != "" {
-		return fmt.Errorf(data.Error.String())
+	if err = data.err(); err != nil {
+		return err
 	}
-	// TODO Assumes succses if no error, should probably check result
 
-	return nil
+	return mergeResult(data.Result)
 }
 
 // UpdatePath writes the view into the specified tree and path. Overwrites existing values.
 func (view *View) UpdatePath(t Task, tree string, path Path) error {
+	return view.UpdatePathContext(view.context(), t, tree, path)
+}
+
+// UpdatePathContext is like UpdatePath, but the request is aborted if ctx is cancelled.
+func (view *View) UpdatePathContext(ctx context.Context, t Task, tree string, path Path) error {
 	var data viewUpdateReply
 	var err error
 
@@ -194,21 +260,27 @@ func (view *View) UpdatePath(t Task, tree string, path Path) error {
 	if err != nil {
 		return err
 	}
-	if err = view.srv.Call(uri, &body, &data); err != nil {
+	if err = view.srv.CallContext(ctx, uri, &body, &data); err != nil {
 		return err
 	}
-	if data.Error.String() != "" {
-		return fmt.Errorf(data.Error.String())
+	if err = data.err(); err != nil {
+		return err
 	}
 	if data.Result.String() == "" {
-		return fmt.Errorf("update-path seemed to succeed, but didn't return a hash", path.String(), data.Result.String())
+		return &ProtocolError{Msg: fmt.Sprintf("update-path %s seemed to succeed, but didn't return a hash", path.String())}
 	}
 
 	return nil
 }
 
-// Iter iterates through all keys in a view. Returns results in a channel as they are received.
-func (view *View) Iter() (<-chan *Path, error) {
+// Iter iterates through all keys in a view. Returns results in a channel as they are received. On error, the last item
+// in the channel will have .Error set - the channel is then closed.
+func (view *View) Iter() (<-chan *IterResult, error) {
+	return view.IterContext(view.context())
+}
+
+// IterContext is like Iter, but the stream is stopped and the channel closed when ctx is cancelled.
+func (view *View) IterContext(ctx context.Context) (<-chan *IterResult, error) {
 	var ch <-chan *StreamReply
 	var err error
 	cmd := fmt.Sprintf("view/%s/iter", url.QueryEscape(view.node))
@@ -216,27 +288,101 @@ func (view *View) Iter() (<-chan *Path, error) {
 	if err != nil {
 		return nil, err
 	}
-	if ch, err = view.srv.CallStream(uri, nil); err != nil || ch == nil {
+	if ch, err = view.srv.CallStreamContext(ctx, uri, nil); err != nil || ch == nil {
 		return nil, err
 	}
 
-	out := make(chan *Path, 1)
-
-	go func() {
-		defer close(out)
-		for m := range ch {
-			p := new(Path)
-			if err := json.Unmarshal(m.Result, &p); err != nil {
-				panic(err) // TODO This should be returned to caller
-			}
-			out <- p
-		}
-	}()
-
-	return out, err
+	return iterPaths(ctx, ch), nil
 }
 
 // NewTask creates a new task that can be be submitted with a command. This is used as the commit message by Irmin.
 func (view *View) NewTask(message string) Task {
 	return NewTask(view.srv.taskowner, message)
 }
+
+// Remove removes a key from a view
+func (view *View) Remove(t Task, path Path) error {
+	return view.RemoveContext(view.context(), t, path)
+}
+
+// RemoveContext is like Remove, but the request is aborted if ctx is cancelled.
+func (view *View) RemoveContext(ctx context.Context, t Task, path Path) error {
+	return view.remove(ctx, t, "remove", path)
+}
+
+// RemoveRec removes a key and its subtree recursively from a view
+func (view *View) RemoveRec(t Task, path Path) error {
+	return view.RemoveRecContext(view.context(), t, path)
+}
+
+// RemoveRecContext is like RemoveRec, but the request is aborted if ctx is cancelled.
+func (view *View) RemoveRecContext(ctx context.Context, t Task, path Path) error {
+	return view.remove(ctx, t, "remove-rec", path)
+}
+
+func (view *View) remove(ctx context.Context, t Task, command string, path Path) error {
+	var data viewRemoveReply
+	body := postRequest{t, nil}
+
+	cmd := fmt.Sprintf("view/%s/%s", url.QueryEscape(view.node), command)
+	uri, err := view.srv.MakeCallURL(cmd, path, false)
+	if err != nil {
+		return err
+	}
+	if err = view.srv.CallContext(ctx, uri, &body, &data); err != nil {
+		return err
+	}
+	if err = data.err(); err != nil {
+		return err
+	}
+	if data.Result.String() == "" {
+		return &ProtocolError{Msg: fmt.Sprintf("%s %s seemed to succeed, but didn't return a hash", command, path.String())}
+	}
+
+	view.node = data.Result.String() // Store new node position
+	return nil
+}
+
+// List returns a list of keys in a path in the view
+func (view *View) List(path Path) ([]Path, error) {
+	return view.ListContext(view.context(), path)
+}
+
+// ListContext is like List, but the request is aborted if ctx is cancelled.
+func (view *View) ListContext(ctx context.Context, path Path) ([]Path, error) {
+	var data viewListReply
+	cmd := fmt.Sprintf("view/%s/list", url.QueryEscape(view.node))
+	uri, err := view.srv.MakeCallURL(cmd, path, false)
+	if err != nil {
+		return []Path{}, err
+	}
+	if err = view.srv.CallContext(ctx, uri, nil, &data); err != nil {
+		return []Path{}, err
+	}
+	if err = data.err(); err != nil {
+		return []Path{}, err
+	}
+	return data.Result, nil
+}
+
+// Mem returns true if a path exists in the view
+func (view *View) Mem(path Path) (bool, error) {
+	return view.MemContext(view.context(), path)
+}
+
+// MemContext is like Mem, but the request is aborted if ctx is cancelled.
+func (view *View) MemContext(ctx context.Context, path Path) (bool, error) {
+	var data viewMemReply
+	cmd := fmt.Sprintf("view/%s/mem", url.QueryEscape(view.node))
+	uri, err := view.srv.MakeCallURL(cmd, path, false)
+	if err != nil {
+		return false, err
+	}
+	if err = view.srv.CallContext(ctx, uri, nil, &data); err != nil {
+		return false, err
+	}
+	if err = data.err(); err != nil {
+		return false, err
+	}
+	return data.Result, nil
+}
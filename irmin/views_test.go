@@ -0,0 +1,119 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin_test
+
+import (
+	"testing"
+
+	"github.com/MagnusS/irmin-go/irmin"
+)
+
+func TestViewMergePath(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	r := getConn(t, srv)
+	if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/view-test/exists"), []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := r.CreateView(r.NewTask("create view"), irmin.ParsePath("/view-test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err := v.ReadString(irmin.ParsePath("/exists")); err != nil || s != "hello" {
+		t.Fatalf("expected view to contain /exists=hello, got %s (err=%v)", s, err)
+	}
+	if _, err := v.Update(v.NewTask("add key"), irmin.ParsePath("/from-view"), []byte("world")); err != nil {
+		t.Fatal(err)
+	}
+
+	ch, err := v.Iter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for p := range ch {
+		if p.Error != nil {
+			t.Fatal(p.Error)
+		}
+		paths = append(paths, p.Path.String())
+	}
+	if len(paths) != 2 || paths[0] != "/exists" || paths[1] != "/from-view" {
+		t.Fatalf("unexpected view contents %v", paths)
+	}
+
+	if b, err := r.Mem(irmin.ParsePath("/view-test/from-view")); err != nil || b {
+		t.Fatalf("view should not be visible before merge (mem=%t, err=%v)", b, err)
+	}
+	if err := v.MergePath(v.NewTask("merge view"), "master", irmin.ParsePath("/view-test")); err != nil {
+		t.Fatal(err)
+	}
+	s, err := r.ReadString(irmin.ParsePath("/view-test/from-view"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "world" {
+		t.Fatalf("expected merged value world, got %s", s)
+	}
+}
+
+func TestViewRemoveListMem(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	r := getConn(t, srv)
+	for _, k := range []string{"/view-test/a", "/view-test/b/c", "/view-test/b/d"} {
+		if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath(k), []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	v, err := r.CreateView(r.NewTask("create view"), irmin.ParsePath("/view-test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, err := v.List(irmin.ParsePath("/b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 || paths[0].String() != "/b/c" || paths[1].String() != "/b/d" {
+		t.Fatalf("unexpected list result %v", paths)
+	}
+
+	if err := v.Remove(v.NewTask("remove key"), irmin.ParsePath("/a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.RemoveRec(v.NewTask("remove tree"), irmin.ParsePath("/b")); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"/a", "/b/c"} {
+		if b, err := v.Mem(irmin.ParsePath(k)); err != nil || b {
+			t.Fatalf("expected %s to be removed from view (mem=%t, err=%v)", k, b, err)
+		}
+	}
+	if b, err := r.Mem(irmin.ParsePath("/view-test/a")); err != nil || !b {
+		t.Fatalf("key should not be removed before merge (mem=%t, err=%v)", b, err)
+	}
+
+	if err := v.MergePath(v.NewTask("merge view"), "master", irmin.ParsePath("/view-test")); err != nil {
+		t.Fatal(err)
+	}
+	if paths, err := r.List(irmin.ParsePath("/view-test")); err != nil || len(paths) != 0 {
+		t.Fatalf("expected empty path after merge, got %v (err=%v)", paths, err)
+	}
+}
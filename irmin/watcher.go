@@ -0,0 +1,243 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// WatchState is the connection state of a Watcher
+type WatchState int
+
+const (
+	// WatchConnecting is set while the watch is being (re)started
+	WatchConnecting WatchState = iota
+	// WatchConnected is set when the watch is running
+	WatchConnected
+	// WatchDisconnected is set when the watch failed. The watch is restarted after a delay.
+	WatchDisconnected
+	// WatchStopped is set when the context is cancelled. No more changes will be received.
+	WatchStopped
+)
+
+func (s WatchState) String() string {
+	switch s {
+	case WatchConnecting:
+		return "connecting"
+	case WatchConnected:
+		return "connected"
+	case WatchDisconnected:
+		return "disconnected"
+	case WatchStopped:
+		return "stopped"
+	}
+	return "unknown"
+}
+
+// WatchEvent is sent by a Watcher when the connection state changes
+type WatchEvent struct {
+	State WatchState
+	Err   error         // Reason for disconnect, only set for WatchDisconnected
+	Retry time.Duration // Delay before reconnecting, only set for WatchDisconnected
+}
+
+// errWatchEnded is returned when the server ends a watch stream
+var errWatchEnded = errors.New("watch stream ended")
+
+// Watcher is a recursive watch that is restarted automatically when it fails, see WatchPathResilient
+type Watcher struct {
+	C      <-chan *WatchPathCommit // Changes. Closed when the watcher is stopped. Error is never set.
+	Events <-chan WatchEvent       // Connection state changes. Events are dropped if the channel is full.
+
+	conn    *Conn
+	path    Path
+	backoff Backoff
+	out     chan *WatchPathCommit
+	events  chan WatchEvent
+
+	mu       sync.Mutex
+	last     []byte // last commit delivered to C, nil if the store was empty
+	resolved bool   // set when the starting point is known
+	state    WatchState
+}
+
+// WatchPathResilient watches a path recursively like WatchPath, but restarts the watch with exponential backoff when it
+// fails. The watch is resumed from the last commit delivered on Watcher.C, so no changes are missed or repeated. If
+// firstCommit is nil the watch starts at the current HEAD. If the store is empty, the keys committed before the watch
+// is connected are reported as created in one WatchPathCommit. The watcher runs until ctx is cancelled.
+func (rest *Conn) WatchPathResilient(ctx context.Context, path Path, firstCommit []byte, backoff Backoff) *Watcher {
+	return rest.watchPathResilient(ctx, path, firstCommit, firstCommit != nil, backoff)
+}
+
+// watchPathResilient is like WatchPathResilient. If resolved is set and firstCommit is nil, the watch starts from the
+// empty store, so all keys at the current HEAD are reported as created.
+func (rest *Conn) watchPathResilient(ctx context.Context, path Path, firstCommit []byte, resolved bool, backoff Backoff) *Watcher {
+	w := &Watcher{
+		conn:     rest,
+		path:     path,
+		backoff:  backoff,
+		out:      make(chan *WatchPathCommit, 1),
+		events:   make(chan WatchEvent, 16),
+		last:     firstCommit,
+		resolved: resolved,
+	}
+	w.C = w.out
+	w.Events = w.events
+	go w.run(ctx)
+	return w
+}
+
+// LastCommit returns the last commit delivered by the watcher, or the commit the watch was started from
+func (w *Watcher) LastCommit() []byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.last
+}
+
+// State returns the current connection state
+func (w *Watcher) State() WatchState {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.state
+}
+
+func (w *Watcher) setState(ev WatchEvent) {
+	w.mu.Lock()
+	w.state = ev.State
+	w.mu.Unlock()
+	select {
+	case w.events <- ev:
+	default: // nobody is listening
+	}
+}
+
+func (w *Watcher) run(ctx context.Context) {
+	defer close(w.out)
+	defer w.setState(WatchEvent{State: WatchStopped})
+
+	for attempt := 0; ; attempt++ {
+		w.setState(WatchEvent{State: WatchConnecting})
+		err := w.watch(ctx, func() { attempt = 0 })
+		if ctx.Err() != nil {
+			return
+		}
+
+		d := w.backoff.Delay(attempt)
+		w.conn.logger.Log(LevelWarn, "irmin watch failed, retrying",
+			Field{"path", w.path.String()}, Field{"retry", d}, Field{"error", err})
+		w.setState(WatchEvent{State: WatchDisconnected, Err: err, Retry: d})
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// watch runs one watch until it fails and forwards the changes. connected is called when the watch has started.
+func (w *Watcher) watch(ctx context.Context, connected func()) error {
+	w.mu.Lock()
+	first, resolved := w.last, w.resolved
+	w.mu.Unlock()
+	if !resolved || first == nil {
+		head, err := w.conn.HeadContext(ctx)
+		if err != nil {
+			return err
+		}
+		if resolved && head != nil { // the store was empty, report everything committed while disconnected
+			if err := w.replay(ctx, head); err != nil {
+				return err
+			}
+		}
+		w.mu.Lock() // start from HEAD, so changes made before the first reply are not lost on reconnect
+		w.last, w.resolved = head, true
+		w.mu.Unlock()
+		first = head
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel() // close the stream if forwarding stops
+	ch, err := w.conn.WatchPathContext(streamCtx, w.path, first)
+	if err != nil {
+		return err
+	}
+	if first == nil {
+		// The server starts an empty watch at its own HEAD, so commits made after HEAD was read are not sent. Check
+		// HEAD again now that the stream is open, and if there are commits replay them and resume from there.
+		head, err := w.conn.HeadContext(ctx)
+		if err != nil {
+			return err
+		}
+		if head != nil {
+			cancel()
+			if err := w.replay(ctx, head); err != nil {
+				return err
+			}
+			w.mu.Lock()
+			w.last = head
+			w.mu.Unlock()
+			return w.watch(ctx, connected)
+		}
+	}
+	w.setState(WatchEvent{State: WatchConnected})
+	connected()
+
+	for c := range ch {
+		if c.Error != nil {
+			return c.Error
+		}
+		select {
+		case w.out <- c:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		w.mu.Lock()
+		w.last = c.Commit
+		w.mu.Unlock()
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return errWatchEnded
+}
+
+// replay sends every key below the path at commit as created. Used when the watch started on an empty store, as
+// there is no earlier commit to resume from.
+func (w *Watcher) replay(ctx context.Context, commit []byte) error {
+	entries, err := w.conn.readTreeAt(ctx, commit, w.path)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	c := &WatchPathCommit{Commit: commit, Changes: make([]WatchPathChange, len(entries))}
+	for i, e := range entries {
+		c.Changes[i] = WatchPathChange{Change: KeyCreated, Key: e.path}
+	}
+	sort.Slice(c.Changes, func(i, j int) bool { return c.Changes[i].Key.String() < c.Changes[j].Key.String() })
+	select {
+	case w.out <- c:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,191 @@
+/*
+ Copyright (c) 2015 Magnus Skjegstad <magnus@skjegstad.com>
+
+ Permission to use, copy, modify, and distribute this software for any
+ purpose with or without fee is hereby granted, provided that the above
+ copyright notice and this permission notice appear in all copies.
+
+ THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+*/
+
+package irmin_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MagnusS/irmin-go/irmin"
+)
+
+func TestWatchPathResilient(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	// Keep-alive connections are closed by CloseClientConnections, so disable them for updates
+	r := srv.Conn("irmin-go-tester", irmin.WithHTTPClient(&http.Client{Transport: &http.Transport{DisableKeepAlives: true}}))
+	if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/resilient/a"), []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := getConn(t, srv).WatchPathResilient(ctx, irmin.ParsePath("/resilient"), nil, irmin.Backoff{Initial: 50 * time.Millisecond})
+
+	waitState := func(state irmin.WatchState) {
+		for {
+			select {
+			case ev := <-w.Events:
+				if ev.State == state {
+					return
+				}
+			case <-time.After(1 * time.Second):
+				t.Fatalf("Timed out while waiting for watcher state %s, state is %s", state, w.State())
+			}
+		}
+	}
+	expect := func(value string) {
+		select {
+		case c := <-w.C:
+			if len(c.Changes) != 1 || c.Changes[0].Change != irmin.KeyUpdated {
+				t.Fatalf("expected update of /resilient/a, got %v", c.Changes)
+			}
+			if v, err := r.FromTree(hex.EncodeToString(c.Commit)).ReadString(irmin.ParsePath("/resilient/a")); err != nil || v != value {
+				t.Fatalf("expected %s at commit, got %s (%v)", value, v, err)
+			}
+			if !bytes.Equal(w.LastCommit(), c.Commit) {
+				t.Fatal("LastCommit was not updated")
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatal("Timed out while waiting for WatchPathResilient result")
+		}
+	}
+
+	waitState(irmin.WatchConnected)
+	if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/resilient/a"), []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	expect("bar")
+
+	// Update while disconnected. The change should be delivered once after reconnecting.
+	srv.CloseClientConnections()
+	waitState(irmin.WatchDisconnected)
+	if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/resilient/a"), []byte("baz")); err != nil {
+		t.Fatal(err)
+	}
+	waitState(irmin.WatchConnected)
+	expect("baz")
+
+	select {
+	case c := <-w.C:
+		t.Fatalf("unexpected change after reconnect: %v", c.Changes)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+	waitState(irmin.WatchStopped)
+	if _, ok := <-w.C; ok {
+		t.Fatal("channel not closed after cancel")
+	}
+}
+
+func TestWatchPathResilientEmptyStore(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	r := srv.Conn("irmin-go-tester", irmin.WithHTTPClient(&http.Client{Transport: &http.Transport{DisableKeepAlives: true}}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := getConn(t, srv).WatchPathResilient(ctx, irmin.ParsePath("/p"), nil, irmin.Backoff{Initial: 50 * time.Millisecond})
+
+	waitState := func(state irmin.WatchState) {
+		for {
+			select {
+			case ev := <-w.Events:
+				if ev.State == state {
+					return
+				}
+			case <-time.After(1 * time.Second):
+				t.Fatalf("Timed out while waiting for watcher state %s, state is %s", state, w.State())
+			}
+		}
+	}
+
+	// Commit to the empty store while disconnected. The key should be reported as created after reconnecting.
+	waitState(irmin.WatchConnected)
+	srv.CloseClientConnections()
+	waitState(irmin.WatchDisconnected)
+	hash, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/p/k"), []byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case c := <-w.C:
+		if len(c.Changes) != 1 || c.Changes[0].Change != irmin.KeyCreated || c.Changes[0].Key.String() != "/p/k" {
+			t.Fatalf("expected /p/k to be created, got %v", c.Changes)
+		}
+		if hex.EncodeToString(c.Commit) != hash || !bytes.Equal(w.LastCommit(), c.Commit) {
+			t.Fatalf("expected commit %s, got %x", hash, c.Commit)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timed out while waiting for key committed while disconnected")
+	}
+
+	// The watch resumes from the replayed commit
+	if _, err := r.Update(r.NewTask("update key"), irmin.ParsePath("/p/k"), []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case c := <-w.C:
+		if len(c.Changes) != 1 || c.Changes[0].Change != irmin.KeyUpdated {
+			t.Fatalf("expected /p/k to be updated, got %v", c.Changes)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timed out while waiting for update after replay")
+	}
+}
+
+func TestWatchPathResilientEmptyStoreCommitBeforeStream(t *testing.T) {
+	srv := spawnIrmin(t)
+	defer stopIrmin(t, srv)
+
+	// Commit after the watcher has read the empty HEAD, but before the stream is opened
+	r := getConn(t, srv)
+	var once sync.Once
+	var hash string
+	commit := func(next irmin.Handler) irmin.Handler {
+		return func(ctx context.Context, req *irmin.Request) (*irmin.Response, error) {
+			var err error
+			if req.Command == "watch-rec" {
+				once.Do(func() { hash, err = r.Update(r.NewTask("update key"), irmin.ParsePath("/p/k"), []byte("foo")) })
+			}
+			if err != nil {
+				return nil, err
+			}
+			return next(ctx, req)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := srv.Conn("irmin-go-tester", irmin.WithMiddleware(commit)).WatchPathResilient(ctx, irmin.ParsePath("/p"), nil, irmin.Backoff{})
+	select {
+	case c := <-w.C:
+		if len(c.Changes) != 1 || c.Changes[0].Change != irmin.KeyCreated || c.Changes[0].Key.String() != "/p/k" {
+			t.Fatalf("expected /p/k to be created, got %v", c.Changes)
+		}
+		if hex.EncodeToString(c.Commit) != hash {
+			t.Fatalf("expected commit %s, got %x", hash, c.Commit)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timed out while waiting for key committed before the stream was opened")
+	}
+}